@@ -0,0 +1,182 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Let's Encrypt 的 ACME 目錄；Config.ACMECA 空白代表正式環境
+const (
+	letsEncryptProduction = "https://acme-v02.api.letsencrypt.org/directory"
+	letsEncryptStaging    = "https://acme-staging-v02.api.letsencrypt.org/directory"
+)
+
+// 自訂 ACME CA 的根憑證複製到 data/caddy_config，Caddy 容器以 /config 掛載此目錄
+const (
+	acmeCARootFile      = "data/caddy_config/acme-ca-root.pem"
+	acmeCARootContainer = "/config/acme-ca-root.pem"
+)
+
+// normalizeACMECA 將 production、staging 或 ACME 目錄網址轉為 Caddyfile 的 acme_ca，正式環境回傳空字串
+func normalizeACMECA(ca string) (string, error) {
+	ca = strings.TrimSpace(ca)
+	switch strings.ToLower(ca) {
+	case "", "production", letsEncryptProduction:
+		return "", nil
+	case "staging", letsEncryptStaging:
+		return letsEncryptStaging, nil
+	}
+	u, err := url.Parse(ca)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return "", fmt.Errorf("無效的 ACME CA %q，請使用 production、staging 或 https:// 開頭的 ACME 目錄網址", ca)
+	}
+	return ca, nil
+}
+
+// customACMECA 回傳 ca 是否為 Let's Encrypt 以外的 ACME CA
+func customACMECA(ca string) bool {
+	return ca != "" && ca != letsEncryptStaging
+}
+
+// acmeCAName 回傳顯示用的 ACME CA 名稱
+func acmeCAName(ca string) string {
+	switch ca {
+	case "":
+		return "Let's Encrypt"
+	case letsEncryptStaging:
+		return "Let's Encrypt staging"
+	}
+	return ca
+}
+
+// checkACMECARoot 確認根憑證檔案含有至少一張 PEM 格式的憑證
+func checkACMECARoot(file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("無法讀取 ACME CA 根憑證: %w", err)
+	}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return fmt.Errorf("%s 沒有 PEM 格式的憑證", file)
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+			return fmt.Errorf("%s 的憑證無法解析: %w", file, err)
+		}
+		return nil
+	}
+}
+
+// installACMECARoot 將根憑證複製到 Caddy 容器可讀取的 data/caddy_config
+func installACMECARoot(cfg *Config) error {
+	if cfg.ACMECARoot == "" || filepath.Clean(cfg.ACMECARoot) == acmeCARootFile {
+		return nil
+	}
+	data, err := os.ReadFile(cfg.ACMECARoot)
+	if err != nil {
+		return fmt.Errorf("無法讀取 ACME CA 根憑證: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(acmeCARootFile), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(acmeCARootFile, data, 0644); err != nil {
+		return err
+	}
+	green.Printf("已將 ACME CA 根憑證 %s 複製到 %s\n", cfg.ACMECARoot, acmeCARootFile)
+	cfg.ACMECARoot = acmeCARootFile
+	return nil
+}
+
+// caddyGlobalOptions 回傳 cfg 的 ACME CA 對應的 Caddyfile 全域設定
+func caddyGlobalOptions(cfg *Config) []string {
+	var opts []string
+	if cfg.ACMECA != "" {
+		opts = append(opts, "acme_ca "+cfg.ACMECA)
+	}
+	if cfg.ACMECARoot != "" {
+		opts = append(opts, "acme_ca_root "+acmeCARootContainer)
+	}
+	return opts
+}
+
+// addCaddyGlobalOptions 將 opts 加入 Caddyfile 開頭的全域設定區塊，沒有全域設定區塊時新增一個
+func addCaddyGlobalOptions(content string, opts []string) string {
+	if len(opts) == 0 {
+		return content
+	}
+	lines := strings.Split(content, "\n")
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if line != "{" {
+			break
+		}
+		added := make([]string, 0, len(lines)+len(opts))
+		added = append(added, lines[:i+1]...)
+		for _, opt := range opts {
+			added = append(added, "    "+opt)
+		}
+		return strings.Join(append(added, lines[i+1:]...), "\n")
+	}
+	return "{\n    " + strings.Join(opts, "\n    ") + "\n}\n" + content
+}
+
+// getACMEFromCaddyfile 讀取 Caddyfile 全域設定中的 acme_ca 與 acme_ca_root，根憑證回傳主機上的路徑
+func getACMEFromCaddyfile() (ca, root string) {
+	data, err := os.ReadFile(caddyfile)
+	if err != nil {
+		return "", ""
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		switch fields[0] {
+		case "acme_ca":
+			ca, _ = normalizeACMECA(fields[1])
+		case "acme_ca_root":
+			root = fields[1]
+			if strings.HasPrefix(root, "/config/") {
+				root = filepath.Join(filepath.Dir(acmeCARootFile), path.Base(root))
+			}
+		}
+	}
+	return ca, root
+}
+
+// validateACMECA 檢查有變更的 ACME CA 設定；before 為 nil 時全部檢查
+func validateACMECA(before, cfg *Config) error {
+	if before == nil || cfg.ACMECA != before.ACMECA {
+		ca, err := normalizeACMECA(cfg.ACMECA)
+		if err != nil {
+			return err
+		}
+		cfg.ACMECA = ca
+	}
+	rootChanged := before == nil || cfg.ACMECARoot != before.ACMECARoot
+	if !customACMECA(cfg.ACMECA) {
+		// 改回 Let's Encrypt 時不再需要原本的根憑證
+		if cfg.ACMECARoot != "" && rootChanged {
+			return fmt.Errorf("ACME CA 根憑證只能搭配自訂的 ACME CA 使用")
+		}
+		cfg.ACMECARoot = ""
+		return nil
+	}
+	if cfg.ACMECARoot != "" && rootChanged {
+		return checkACMECARoot(cfg.ACMECARoot)
+	}
+	return nil
+}
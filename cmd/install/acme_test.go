@@ -0,0 +1,209 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNormalizeACMECA(t *testing.T) {
+	const custom = "https://ca.example.internal/acme/directory"
+	tests := []struct {
+		ca      string
+		want    string
+		wantErr bool
+	}{
+		{ca: "", want: ""},
+		{ca: "production", want: ""},
+		{ca: " Production ", want: ""},
+		{ca: letsEncryptProduction, want: ""},
+		{ca: "staging", want: letsEncryptStaging},
+		{ca: "STAGING", want: letsEncryptStaging},
+		{ca: letsEncryptStaging, want: letsEncryptStaging},
+		{ca: custom, want: custom},
+		{ca: " " + custom + " ", want: custom},
+		{ca: "http://ca.example.internal/acme/directory", wantErr: true},
+		{ca: "ftp://ca.example.internal/", wantErr: true},
+		{ca: "ca.example.internal/acme/directory", wantErr: true},
+		{ca: "https://", wantErr: true},
+		{ca: "https:///directory", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := normalizeACMECA(tt.ca)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("normalizeACMECA(%q) error = %v, wantErr %v", tt.ca, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("normalizeACMECA(%q) = %q, want %q", tt.ca, got, tt.want)
+		}
+	}
+}
+
+func TestAddCaddyGlobalOptions(t *testing.T) {
+	opts := []string{"acme_ca https://ca.example.internal/acme/directory", "acme_ca_root " + acmeCARootContainer}
+	tests := []struct {
+		name    string
+		content string
+		opts    []string
+		want    string
+	}{
+		{
+			name:    "no options",
+			content: "{\n    email admin@example.org\n}\ncrm.example.org {\n}\n",
+			want:    "{\n    email admin@example.org\n}\ncrm.example.org {\n}\n",
+		},
+		{
+			name:    "existing global block",
+			content: "{\n    email admin@example.org\n}\ncrm.example.org {\n}\n",
+			opts:    opts,
+			want: "{\n    acme_ca https://ca.example.internal/acme/directory\n    acme_ca_root /config/acme-ca-root.pem\n" +
+				"    email admin@example.org\n}\ncrm.example.org {\n}\n",
+		},
+		{
+			name:    "global block after comments",
+			content: "# site\n\n{\n    email admin@example.org\n}\n",
+			opts:    opts[:1],
+			want:    "# site\n\n{\n    acme_ca https://ca.example.internal/acme/directory\n    email admin@example.org\n}\n",
+		},
+		{
+			name:    "missing global block",
+			content: "crm.example.org {\n    reverse_proxy neticrm-nginx:80\n}\n",
+			opts:    opts,
+			want: "{\n    acme_ca https://ca.example.internal/acme/directory\n    acme_ca_root /config/acme-ca-root.pem\n}\n" +
+				"crm.example.org {\n    reverse_proxy neticrm-nginx:80\n}\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := addCaddyGlobalOptions(tt.content, tt.opts); got != tt.want {
+				t.Errorf("addCaddyGlobalOptions() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetACMEFromCaddyfile(t *testing.T) {
+	const site = "{\n    email admin@example.org\n}\ncrm.example.org {\n    reverse_proxy neticrm-nginx:80\n}\n"
+	tests := []struct {
+		name string
+		cfg  Config
+	}{
+		{name: "production", cfg: Config{}},
+		{name: "staging", cfg: Config{ACMECA: letsEncryptStaging}},
+		{name: "custom CA", cfg: Config{ACMECA: "https://ca.example.internal/acme/directory"}},
+		{name: "custom CA with root", cfg: Config{ACMECA: "https://ca.example.internal/acme/directory", ACMECARoot: acmeCARootFile}},
+	}
+	t.Chdir(t.TempDir())
+	if err := os.MkdirAll(filepath.Dir(caddyfile), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content := addCaddyGlobalOptions(site, caddyGlobalOptions(&tt.cfg))
+			if err := os.WriteFile(caddyfile, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			ca, root := getACMEFromCaddyfile()
+			wantRoot := tt.cfg.ACMECARoot
+			if wantRoot != "" {
+				// 根憑證回傳主機上的路徑
+				wantRoot = filepath.FromSlash(wantRoot)
+			}
+			if ca != tt.cfg.ACMECA || root != wantRoot {
+				t.Errorf("getACMEFromCaddyfile() = %q, %q, want %q, %q", ca, root, tt.cfg.ACMECA, wantRoot)
+			}
+		})
+	}
+}
+
+// writeTestCertificate 在 dir 寫入一張自簽的 PEM 憑證並回傳檔案路徑
+func writeTestCertificate(t *testing.T, dir string) string {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test ACME Root"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "root.pem")
+	if err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestValidateACMECA(t *testing.T) {
+	const custom = "https://ca.example.internal/acme/directory"
+	dir := t.TempDir()
+	root := writeTestCertificate(t, dir)
+	notPEM := filepath.Join(dir, "root.txt")
+	if err := os.WriteFile(notPEM, []byte("not a certificate\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		before   *Config
+		cfg      Config
+		wantCA   string
+		wantRoot string
+		wantErr  bool
+	}{
+		{name: "staging alias", cfg: Config{ACMECA: "staging"}, wantCA: letsEncryptStaging},
+		{name: "production alias", cfg: Config{ACMECA: "production"}},
+		{name: "custom CA", cfg: Config{ACMECA: custom}, wantCA: custom},
+		{name: "custom CA with root", cfg: Config{ACMECA: custom, ACMECARoot: root}, wantCA: custom, wantRoot: root},
+		{name: "invalid CA", cfg: Config{ACMECA: "http://ca.example.internal/"}, wantErr: true},
+		{name: "root without custom CA", cfg: Config{ACMECARoot: root}, wantErr: true},
+		{name: "root with staging", cfg: Config{ACMECA: "staging", ACMECARoot: root}, wantErr: true},
+		{name: "root is not PEM", cfg: Config{ACMECA: custom, ACMECARoot: notPEM}, wantErr: true},
+		{name: "missing root", cfg: Config{ACMECA: custom, ACMECARoot: filepath.Join(dir, "missing.pem")}, wantErr: true},
+		{
+			// 沒有變更的根憑證不再檢查，例如已複製到 data/caddy_config 的檔案
+			name:     "unchanged root",
+			before:   &Config{ACMECA: custom, ACMECARoot: acmeCARootFile},
+			cfg:      Config{ACMECA: custom, ACMECARoot: acmeCARootFile},
+			wantCA:   custom,
+			wantRoot: acmeCARootFile,
+		},
+		{
+			name:   "back to Let's Encrypt drops the root",
+			before: &Config{ACMECA: custom, ACMECARoot: acmeCARootFile},
+			cfg:    Config{ACMECA: "production", ACMECARoot: acmeCARootFile},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.cfg
+			err := validateACMECA(tt.before, &cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validateACMECA() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if cfg.ACMECA != tt.wantCA || cfg.ACMECARoot != tt.wantRoot {
+				t.Errorf("validateACMECA() = %q, %q, want %q, %q", cfg.ACMECA, cfg.ACMECARoot, tt.wantCA, tt.wantRoot)
+			}
+		})
+	}
+}
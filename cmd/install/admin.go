@@ -0,0 +1,212 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/AlecAivazis/survey/v2"
+	"github.com/netivism/neticrm-selfhost/internal/envfile"
+)
+
+// floodClearScript 清除 Drupal flood 表中登入與密碼重設的失敗紀錄，輸出刪除的筆數
+const floodClearScript = `$db = \Drupal::database();
+if (!$db->schema()->tableExists('flood')) {
+  echo 0;
+  return;
+}
+echo $db->delete('flood')->condition('event', 'user.%', 'LIKE')->execute();`
+
+// adminOptions 為 reset-password 與 unlock 共用的旗標
+type adminOptions struct {
+	user      *string
+	generate  *bool
+	updateEnv *bool
+}
+
+func addAdminFlags(fs *flag.FlagSet) adminOptions {
+	return adminOptions{
+		user:      fs.String("user", "", "帳號名稱（預設為 .env 的 ADMIN_LOGIN_USER）"),
+		generate:  fs.Bool("generate", false, "不詢問新密碼，直接產生隨機密碼"),
+		updateEnv: fs.Bool("update-env", false, "將新密碼寫入 .env 的 ADMIN_LOGIN_PASSWORD（僅限 ADMIN_LOGIN_USER）"),
+	}
+}
+
+func runAdmin(args []string) error {
+	fs := newFlagSet("admin")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return newUsageError("未知的子命令: %s", fs.Arg(0))
+	}
+	return newUsageError("請指定子命令 reset-password 或 unlock")
+}
+
+func runAdminResetPassword(args []string) error {
+	fs := newFlagSet("admin reset-password")
+	opts := addAdminFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := noArgs(fs); err != nil {
+		return err
+	}
+	cfg, user, err := prepareAdminCommand(opts)
+	if err != nil {
+		return err
+	}
+	return resetAdminPassword(cfg, user, opts)
+}
+
+func runAdminUnlock(args []string) error {
+	fs := newFlagSet("admin unlock")
+	opts := addAdminFlags(fs)
+	resetPassword := fs.Bool("reset-password", false, "解除鎖定後一併重設密碼")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := noArgs(fs); err != nil {
+		return err
+	}
+	cfg, user, err := prepareAdminCommand(opts)
+	if err != nil {
+		return err
+	}
+
+	out, err := exec.Command("docker", "exec", phpContainer, "drush", "php:eval", floodClearScript).Output()
+	if err != nil {
+		return fmt.Errorf("清除登入失敗紀錄失敗: %w", err)
+	}
+	green.Printf("✓ 已清除 %s 筆登入失敗紀錄\n", strings.TrimSpace(string(out)))
+
+	cmd := exec.Command("docker", "exec", phpContainer, "drush", "user:unblock", user)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("解除帳號 %s 的封鎖失敗: %w", user, err)
+	}
+	green.Printf("✓ 帳號 %s 已啟用\n", user)
+
+	if *resetPassword {
+		return resetAdminPassword(cfg, user, opts)
+	}
+	if *opts.updateEnv || *opts.generate {
+		yellow.Println("未指定 --reset-password，沒有變更密碼。")
+	}
+	return nil
+}
+
+// prepareAdminCommand 檢查網站已啟動且帳號存在，回傳目前設定與帳號名稱
+func prepareAdminCommand(opts adminOptions) (*Config, string, error) {
+	if err := requireInstalled(); err != nil {
+		return nil, "", err
+	}
+	if err := checkDocker(); err != nil {
+		return nil, "", err
+	}
+	if !containerRunning(phpContainer) {
+		return nil, "", fmt.Errorf("容器 %s 未執行，請先執行 %s start", phpContainer, programName())
+	}
+	cfg, err := loadCurrentConfig()
+	if err != nil {
+		return nil, "", err
+	}
+	user := *opts.user
+	if user == "" {
+		user = loginUser(cfg)
+	}
+	if *opts.updateEnv && user != loginUser(cfg) {
+		return nil, "", newUsageError("--update-env 只能用於 ADMIN_LOGIN_USER（%s）", loginUser(cfg))
+	}
+
+	if out, err := exec.Command("docker", "exec", phpContainer,
+		"drush", "user:information", user, "--fields=name", "--format=string").CombinedOutput(); err != nil {
+		return nil, "", fmt.Errorf("找不到帳號 %s: %s", user, strings.TrimSpace(string(out)))
+	}
+	return cfg, user, nil
+}
+
+// resetAdminPassword 以 drush user:password 設定新密碼，並依選項更新 .env
+func resetAdminPassword(cfg *Config, user string, opts adminOptions) error {
+	var password string
+	if !*opts.generate {
+		var err error
+		if password, err = askNewPassword(user); err != nil {
+			return err
+		}
+	}
+	generated := password == ""
+	if generated {
+		password = randomPass(11)
+	}
+
+	// 以環境變數傳入密碼，避免出現在主機的行程列表
+	cmd := exec.Command("docker", "exec", "-e", "ADMIN_NEW_PASSWORD", phpContainer,
+		"sh", "-c", `drush user:password "$0" "$ADMIN_NEW_PASSWORD"`, user)
+	cmd.Env = append(os.Environ(), "ADMIN_NEW_PASSWORD="+password)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("重設帳號 %s 的密碼失敗: %w", user, err)
+	}
+	green.Printf("✓ 已重設帳號 %s 的密碼\n", user)
+
+	updateEnv := *opts.updateEnv
+	if !updateEnv && user == loginUser(cfg) && !*opts.generate {
+		prompt := &survey.Confirm{
+			Message: fmt.Sprintf("是否將新密碼寫入 %s 的 ADMIN_LOGIN_PASSWORD？", targetFile),
+			Default: false,
+		}
+		if err := survey.AskOne(prompt, &updateEnv); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case updateEnv:
+		if err := storeAdminPassword(password); err != nil {
+			return err
+		}
+		green.Printf("✓ 已將新密碼存入 %s（權限 0600），管理員下次登入後會自動移除\n", targetFile)
+	case user == loginUser(cfg) && cfg.AdminLoginPassword != "":
+		// .env 中的舊密碼已無法登入，移除以免混淆
+		env, err := envfile.Load(targetFile)
+		if err == nil {
+			err = clearAdminPassword(env)
+		}
+		if err != nil {
+			return fmt.Errorf("更新 %s 失敗: %w", targetFile, err)
+		}
+		yellow.Printf("已將失效的 ADMIN_LOGIN_PASSWORD 自 %s 移除。\n", targetFile)
+	}
+
+	if generated {
+		fmt.Printf("新密碼：%s\n", password)
+	}
+	return nil
+}
+
+// askNewPassword 詢問帳號的新密碼，留空表示自動產生
+func askNewPassword(user string) (string, error) {
+	for {
+		var password, confirm string
+		prompt := &survey.Password{Message: fmt.Sprintf("帳號 %s 的新密碼（留空自動產生）：", user)}
+		if err := survey.AskOne(prompt, &password); err != nil {
+			return "", err
+		}
+		if password == "" {
+			return "", nil
+		}
+		prompt = &survey.Password{Message: "請再次輸入新密碼確認："}
+		if err := survey.AskOne(prompt, &confirm); err != nil {
+			return "", err
+		}
+		if password == confirm {
+			return password, nil
+		}
+		red.Println("✗ 兩次密碼不一致，請重新輸入。")
+	}
+}
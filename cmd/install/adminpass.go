@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/netivism/neticrm-selfhost/internal/envfile"
+)
+
+// init-10.sh 在 ADMIN_LOGIN_PASSWORD 空白時自動產生密碼，並寫入只有 root 可以讀取的檔案；
+// nginx 不提供 /log/ 下的檔案
+const (
+	adminPasswordFile          = "data/www/log/admin-password"
+	adminPasswordContainerPath = "/var/www/html/log/admin-password"
+)
+
+// adminPasswordSetKey 記錄 ADMIN_LOGIN_PASSWORD 存入 .env 的時間，管理員在此之後登入才移除密碼
+const adminPasswordSetKey = "ADMIN_LOGIN_PASSWORD_SET_AT"
+
+// captureAdminPassword 將 init-10.sh 產生的管理員密碼存入 .env 並刪除原檔，回傳取得的密碼；
+// 沒有自動產生的密碼時回傳空字串
+func captureAdminPassword() (string, error) {
+	data, err := os.ReadFile(adminPasswordFile)
+	viaContainer := false
+	if errors.Is(err, fs.ErrPermission) {
+		// 不是以 root 執行時改由容器內讀取
+		data, err = exec.Command("docker", "exec", phpContainer, "cat", adminPasswordContainerPath).Output()
+		viaContainer = true
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("讀取 %s 失敗: %w", adminPasswordFile, err)
+	}
+	password := strings.TrimSpace(string(data))
+	if password == "" {
+		return "", nil
+	}
+
+	if err := storeAdminPassword(password); err != nil {
+		return "", err
+	}
+
+	if viaContainer {
+		err = exec.Command("docker", "exec", phpContainer, "rm", "-f", adminPasswordContainerPath).Run()
+	} else {
+		err = os.Remove(adminPasswordFile)
+	}
+	if err != nil {
+		yellow.Printf("⚠️  無法刪除 %s: %v\n", adminPasswordFile, err)
+	}
+
+	green.Printf("✓ 已將初始化程式產生的管理員密碼存入 %s（權限 0600），管理員首次登入後會自動移除\n", targetFile)
+	return password, nil
+}
+
+// storeAdminPassword 將管理員密碼與存入時間寫入 .env
+func storeAdminPassword(password string) error {
+	// .env 含有密碼，先限制權限再寫入
+	if err := os.Chmod(targetFile, 0600); err != nil {
+		return err
+	}
+	err := updateEnvFile(map[string]string{
+		"ADMIN_LOGIN_PASSWORD": password,
+		adminPasswordSetKey:    strconv.FormatInt(time.Now().Unix(), 10),
+	})
+	if err != nil {
+		return fmt.Errorf("更新 %s 失敗: %w", targetFile, err)
+	}
+	return nil
+}
+
+// adminLastLogin 以 drush 查詢帳號最後登入的時間（Unix 秒數），從未登入時為 0
+func adminLastLogin(user string) (int64, error) {
+	out, err := exec.Command("docker", "exec", phpContainer,
+		"drush", "user:information", user, "--fields=login", "--format=string").Output()
+	if err != nil {
+		return 0, fmt.Errorf("查詢帳號 %s 失敗: %w", user, err)
+	}
+	login, err := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("無法解析帳號 %s 的登入時間: %q", user, strings.TrimSpace(string(out)))
+	}
+	return login, nil
+}
+
+// forgetAdminPasswordAfterLogin 在管理員於密碼存入 .env 後登入過時將密碼自 .env 移除，回傳是否已移除。
+// 網站未啟動或無法查詢時保留密碼；沒有記錄存入時間的 .env 只要登入過就移除
+func forgetAdminPasswordAfterLogin() bool {
+	env, err := envfile.Load(targetFile)
+	if err != nil {
+		return false
+	}
+	if password, _ := env.Get("ADMIN_LOGIN_PASSWORD"); password == "" || !siteRunning() {
+		return false
+	}
+	user, _ := env.Get("ADMIN_LOGIN_USER")
+	user = adminUserOrDefault(user)
+	setAt, _ := env.Get(adminPasswordSetKey)
+	since, _ := strconv.ParseInt(setAt, 10, 64)
+	if login, err := adminLastLogin(user); err != nil || login == 0 || login < since {
+		return false
+	}
+
+	if err := clearAdminPassword(env); err != nil {
+		yellow.Printf("⚠️  無法自 %s 移除管理員密碼: %v\n", targetFile, err)
+		return false
+	}
+	yellow.Printf("管理員 %s 已登入過，密碼已自 %s 移除。\n", user, targetFile)
+	return true
+}
+
+// clearAdminPassword 將 ADMIN_LOGIN_PASSWORD 清空並寫回 .env
+func clearAdminPassword(env *envfile.File) error {
+	env.Set("ADMIN_LOGIN_PASSWORD", "")
+	env.Unset(adminPasswordSetKey)
+	return env.WriteFile(targetFile, 0600)
+}
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 非互動模式下，已安裝網站的處理方式（對應 goCheck 選單）
+const (
+	existingStart        = "start"
+	existingOverwrite    = "overwrite"
+	existingLoginLink    = "login-link"
+	existingShowPassword = "show-password"
+	existingExit         = "exit"
+)
+
+// answers 保存非互動安裝的回答，來源依優先順序為命令列旗標、NETICRM_* 環境變數、答案檔
+type answers struct {
+	NonInteractive bool
+	ConfigFile     string
+
+	Language           string
+	Domain             string
+	Email              string
+	SSL                *bool
+	ACMECA             string
+	ACMECARoot         string
+	Port               string
+	HTTPBind           string
+	MySQLRootPassword  string
+	MySQLDatabase      string
+	MySQLUser          string
+	MySQLPassword      string
+	AdminLoginUser     string
+	AdminLoginPassword string
+	BackupRecipients   string
+	NeticrmVersion     string
+	WaitTimeout        string
+
+	// goCheck 選單的對應選項
+	Existing   string
+	BackupData *bool
+	EnvOnly    *bool
+}
+
+// answerField 描述一個可由旗標、環境變數或答案檔設定的欄位
+type answerField struct {
+	name  string
+	usage string
+	str   *string
+	b     **bool
+	// env 不為空時取代預設的 NETICRM_* 環境變數名稱
+	env string
+}
+
+func (a *answers) fields() []answerField {
+	return []answerField{
+		{name: "language", usage: "安裝語言：en 或 zh-hant", str: &a.Language},
+		{name: "domain", usage: "網站網域", str: &a.Domain},
+		{name: "email", usage: "Let's Encrypt 憑證使用的電子郵件", str: &a.Email},
+		{name: "ssl", usage: "使用 Caddy 自動設定 SSL", b: &a.SSL},
+		{name: "acme-ca", usage: "Caddy 申請憑證的 ACME CA：production、staging（Let's Encrypt 測試環境）或 ACME 目錄網址（預設 production）", str: &a.ACMECA},
+		{name: "acme-ca-root", usage: "自訂 ACME CA 的根憑證 PEM 檔", str: &a.ACMECARoot},
+		{name: "port", usage: "未使用 SSL 時 nginx 使用的 HTTP 埠（預設 8080）", str: &a.Port},
+		{name: "http-bind", usage: "未使用 SSL 時 nginx 監聽的位址，例如 127.0.0.1 或 ::1（預設為所有網路介面）", str: &a.HTTPBind},
+		{name: "mysql-root-password", usage: "MYSQL_ROOT_PASSWORD（留空自動產生）", str: &a.MySQLRootPassword},
+		{name: "mysql-database", usage: "MYSQL_DATABASE（留空使用預設值）", str: &a.MySQLDatabase},
+		{name: "mysql-user", usage: "MYSQL_USER（留空使用預設值）", str: &a.MySQLUser},
+		{name: "mysql-password", usage: "MYSQL_PASSWORD（留空自動產生）", str: &a.MySQLPassword},
+		{name: "admin-user", usage: "ADMIN_LOGIN_USER（預設 admin）", str: &a.AdminLoginUser},
+		{name: "admin-password", usage: "ADMIN_LOGIN_PASSWORD（留空自動產生）", str: &a.AdminLoginPassword},
+		{name: "neticrm-version", usage: "NETICRM_VERSION：安裝的 netiCRM 版本（預設為目前最新版）", str: &a.NeticrmVersion, env: "NETICRM_VERSION"},
+		{name: "backup-recipients", usage: "BACKUP_AGE_RECIPIENTS：備份加密用的 age 公鑰，多個以逗號分隔", str: &a.BackupRecipients},
+		{name: "wait-timeout", usage: "等待網站初始化完成的時間，例如 30m（預設 20m，0 表示不等待）", str: &a.WaitTimeout},
+		{name: "existing", usage: "已有安裝時的動作：start、overwrite、login-link、show-password、exit", str: &a.Existing},
+		{name: "backup-data", usage: "覆蓋設定時是否備份 data/mariadb_data 與 data/www（預設 true）", b: &a.BackupData},
+		{name: "env-only", usage: "未安裝 Docker 時仍繼續寫入 .env", b: &a.EnvOnly},
+	}
+}
+
+// envName 回傳欄位對應的環境變數名稱，例如 mysql-user => NETICRM_MYSQL_USER
+func (f answerField) envName() string {
+	if f.env != "" {
+		return f.env
+	}
+	return "NETICRM_" + strings.ToUpper(strings.ReplaceAll(f.name, "-", "_"))
+}
+
+func (f answerField) isSet() bool {
+	if f.str != nil {
+		return *f.str != ""
+	}
+	return *f.b != nil
+}
+
+func (f answerField) set(value string) error {
+	if f.str != nil {
+		*f.str = value
+		return nil
+	}
+	v, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("%s 必須是 true 或 false: %q", f.name, value)
+	}
+	*f.b = &v
+	return nil
+}
+
+// optionalBool 讓三態布林欄位可以 --ssl 或 --ssl=false 的形式使用
+type optionalBool struct {
+	field answerField
+}
+
+func (o optionalBool) String() string {
+	if o.field.b == nil || *o.field.b == nil {
+		return ""
+	}
+	return strconv.FormatBool(**o.field.b)
+}
+
+func (o optionalBool) Set(value string) error { return o.field.set(value) }
+func (o optionalBool) IsBoolFlag() bool       { return true }
+
+// registerAnswerFlags 在 FlagSet 上註冊所有安裝旗標，回傳由旗標填入的 answers
+func registerAnswerFlags(fs *flag.FlagSet) *answers {
+	a := &answers{}
+	fs.BoolVar(&a.NonInteractive, "non-interactive", false, "不詢問任何問題，缺少必要設定時直接失敗")
+	fs.StringVar(&a.ConfigFile, "config", "", "YAML 或 JSON 格式的答案檔")
+
+	for _, f := range a.fields() {
+		if f.b != nil {
+			fs.Var(optionalBool{f}, f.name, f.usage)
+			continue
+		}
+		fs.Func(f.name, f.usage, f.set)
+	}
+
+	return a
+}
+
+// resolveAnswers 合併答案檔、環境變數與旗標，旗標優先
+func resolveAnswers(fromFlags *answers) (*answers, error) {
+	a := &answers{
+		NonInteractive: fromFlags.NonInteractive,
+		ConfigFile:     fromFlags.ConfigFile,
+	}
+
+	if v := os.Getenv("NETICRM_NON_INTERACTIVE"); v != "" && !a.NonInteractive {
+		ni, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("NETICRM_NON_INTERACTIVE 必須是 true 或 false: %q", v)
+		}
+		a.NonInteractive = ni
+	}
+	if a.ConfigFile == "" {
+		a.ConfigFile = os.Getenv("NETICRM_CONFIG")
+	}
+
+	if a.ConfigFile != "" {
+		if err := a.loadFile(a.ConfigFile); err != nil {
+			return nil, err
+		}
+	}
+
+	fields := a.fields()
+	for _, f := range fields {
+		if v, ok := os.LookupEnv(f.envName()); ok && v != "" {
+			if err := f.set(v); err != nil {
+				return nil, fmt.Errorf("環境變數 %s: %w", f.envName(), err)
+			}
+		}
+	}
+
+	for i, f := range fromFlags.fields() {
+		if !f.isSet() {
+			continue
+		}
+		if f.str != nil {
+			*fields[i].str = *f.str
+		} else {
+			*fields[i].b = *f.b
+		}
+	}
+
+	return a, nil
+}
+
+// loadFile 讀取答案檔，鍵名可使用 mysql-user 或 mysql_user
+func (a *answers) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("讀取答案檔 %s 失敗: %w", path, err)
+	}
+
+	raw := map[string]interface{}{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.Unmarshal(data, &raw)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	default:
+		return fmt.Errorf("不支援的答案檔格式 %s（請使用 .yaml、.yml 或 .json）", path)
+	}
+	if err != nil {
+		return fmt.Errorf("解析答案檔 %s 失敗: %w", path, err)
+	}
+
+	byName := make(map[string]answerField)
+	for _, f := range a.fields() {
+		byName[f.name] = f
+	}
+
+	var unknown []string
+	for key, val := range raw {
+		f, ok := byName[strings.ReplaceAll(key, "_", "-")]
+		if !ok {
+			unknown = append(unknown, key)
+			continue
+		}
+		if val == nil {
+			continue
+		}
+		if err := f.set(fmt.Sprint(val)); err != nil {
+			return fmt.Errorf("答案檔 %s: %w", path, err)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("答案檔 %s 含有未知的欄位: %s", path, strings.Join(unknown, ", "))
+	}
+
+	return nil
+}
+
+// missingFieldsError 列出非互動模式下缺少或不正確的設定
+type missingFieldsError []string
+
+func (e missingFieldsError) Error() string {
+	return "非互動模式缺少或有誤的設定：\n  - " + strings.Join(e, "\n  - ")
+}
+
+func (a *answers) describe(name string) string {
+	for _, f := range a.fields() {
+		if f.name == name {
+			return fmt.Sprintf("%s（--%s 或 %s）", name, f.name, f.envName())
+		}
+	}
+	return name
+}
+
+// validate 在執行任何動作之前檢查所有非互動回答
+func (a *answers) validate() error {
+	var problems missingFieldsError
+
+	switch a.Language {
+	case "":
+		problems = append(problems, a.describe("language")+"：未設定")
+	case "en", "zh-hant":
+	default:
+		problems = append(problems, a.describe("language")+fmt.Sprintf("：不支援 %q，請使用 en 或 zh-hant", a.Language))
+	}
+
+	useSSL := a.SSL != nil && *a.SSL
+	if a.Domain != "" {
+		domain, err := normalizeDomain(a.Domain)
+		if err == nil && useSSL {
+			err = checkCertificateDomain(domain)
+		}
+		if err != nil {
+			problems = append(problems, a.describe("domain")+"："+err.Error())
+		}
+	} else if useSSL {
+		problems = append(problems, a.describe("domain")+"：啟用 SSL 時必須設定")
+	}
+	if a.Email != "" {
+		if _, err := normalizeEmail(a.Email); err != nil {
+			problems = append(problems, a.describe("email")+"："+err.Error())
+		}
+	}
+
+	if ca, err := normalizeACMECA(a.ACMECA); err != nil {
+		problems = append(problems, a.describe("acme-ca")+"："+err.Error())
+	} else if a.ACMECARoot != "" {
+		if !customACMECA(ca) {
+			problems = append(problems, a.describe("acme-ca-root")+"：只能搭配自訂的 ACME CA 使用")
+		} else if err := checkACMECARoot(a.ACMECARoot); err != nil {
+			problems = append(problems, a.describe("acme-ca-root")+"："+err.Error())
+		}
+	}
+
+	if a.Port != "" {
+		if err := validPort(a.Port); err != nil {
+			problems = append(problems, a.describe("port")+"："+err.Error())
+		}
+	}
+	if err := validBind(normalizeBind(a.HTTPBind)); err != nil {
+		problems = append(problems, a.describe("http-bind")+"："+err.Error())
+	}
+
+	if a.NeticrmVersion != "" {
+		if _, err := normalizeNeticrmVersion(a.NeticrmVersion); err != nil {
+			problems = append(problems, a.describe("neticrm-version")+"："+err.Error())
+		}
+	}
+
+	if err := validateRecipients(a.BackupRecipients); err != nil {
+		problems = append(problems, a.describe("backup-recipients")+"："+err.Error())
+	}
+
+	if _, err := a.waitTimeout(); err != nil {
+		problems = append(problems, a.describe("wait-timeout")+"："+err.Error())
+	}
+
+	switch a.Existing {
+	case "", existingStart, existingOverwrite, existingLoginLink, existingShowPassword, existingExit:
+	default:
+		problems = append(problems, a.describe("existing")+fmt.Sprintf("：不支援 %q", a.Existing))
+	}
+
+	if len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+// toConfig 依回答建立 Config，未提供的欄位套用與互動模式相同的預設值
+func (a *answers) toConfig(cfg *Config) {
+	cfg.Language = a.Language
+	// 已由 validate 檢查格式
+	cfg.Domain, _ = normalizeDomain(a.Domain)
+	cfg.Email, _ = normalizeEmail(a.Email)
+	cfg.UseSSL = a.SSL != nil && *a.SSL
+
+	if !cfg.UseSSL {
+		cfg.Port = a.Port
+		if cfg.Port == "" {
+			cfg.Port = "8080"
+		}
+		cfg.HTTPBind = normalizeBind(a.HTTPBind)
+	}
+
+	cfg.MySQLRootPassword = a.MySQLRootPassword
+	if cfg.MySQLRootPassword == "" {
+		cfg.MySQLRootPassword = randomPass(13)
+	}
+	cfg.MySQLDatabase = a.MySQLDatabase
+	cfg.MySQLUser = a.MySQLUser
+	cfg.MySQLPassword = a.MySQLPassword
+	if cfg.MySQLPassword == "" {
+		cfg.MySQLPassword = randomPass(13)
+	}
+
+	cfg.AdminLoginUser = a.AdminLoginUser
+	if cfg.AdminLoginUser == "" {
+		cfg.AdminLoginUser = "admin"
+	}
+	cfg.AdminLoginPassword = a.AdminLoginPassword
+	if cfg.AdminLoginPassword == "" {
+		cfg.AdminLoginPassword = randomPass(11)
+	}
+
+	cfg.NeticrmVersion, _ = normalizeNeticrmVersion(a.NeticrmVersion)
+	cfg.BackupRecipients = a.BackupRecipients
+}
+
+// checkPorts 確認 cfg 需要的埠可以使用，被佔用時列出佔用的程式與建議的埠
+func (a *answers) checkPorts(cfg *Config) error {
+	err := checkSitePorts(cfg)
+	if err == nil {
+		return nil
+	}
+	field := "port"
+	if cfg.UseSSL {
+		field = "ssl"
+	}
+	var busy *portInUseError
+	if errors.As(err, &busy) {
+		return missingFieldsError{a.describe(field) + "：" + busy.Error() + "；" + busy.hint()}
+	}
+	return missingFieldsError{a.describe(field) + "：" + err.Error()}
+}
+
+// waitTimeout 回傳等待網站初始化的時間，未設定時使用預設值
+func (a *answers) waitTimeout() (time.Duration, error) {
+	if a.WaitTimeout == "" {
+		return defaultInitTimeout, nil
+	}
+	d, err := time.ParseDuration(a.WaitTimeout)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("無效的時間 %q，請使用 30m 或 1h 的格式", a.WaitTimeout)
+	}
+	return d, nil
+}
+
+// existingAction 回傳非互動模式下對既有安裝的處理方式
+func (a *answers) existingAction(allowed ...string) (string, error) {
+	if a.Existing == "" {
+		return "", fmt.Errorf("偵測到既有安裝，請以 --existing 或 NETICRM_EXISTING 指定動作（%s）", strings.Join(allowed, "、"))
+	}
+	for _, v := range allowed {
+		if a.Existing == v {
+			return v, nil
+		}
+	}
+	return "", fmt.Errorf("此情況下 --existing 只能是 %s，而非 %q", strings.Join(allowed, "、"), a.Existing)
+}
+
+func (a *answers) backupData() bool {
+	return a.BackupData == nil || *a.BackupData
+}
+
+func (a *answers) envOnly() bool {
+	return a.EnvOnly != nil && *a.EnvOnly
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/netivism/neticrm-selfhost/internal/envfile"
+)
+
+// 網站備份封存檔格式，詳見 docs/backup-format.md
+const (
+	archiveFormat        = "neticrm-site-backup"
+	archiveFormatVersion = 1
+
+	archiveManifest  = "manifest.json"
+	archiveDatabase  = "database.sql"
+	archiveFilesDir  = "files"
+	archiveConfigDir = "config"
+
+	sitesDefaultDir = "data/www/sites/default"
+	siteFilesDir    = sitesDefaultDir + "/files"
+	civicrmDir      = "data/www/modules/civicrm"
+)
+
+// siteConfigFiles 為封存檔 config/ 下的檔名與實際路徑
+var siteConfigFiles = []struct {
+	name string
+	path string
+}{
+	{"settings.php", sitesDefaultDir + "/settings.php"},
+	{"civicrm.settings.php", sitesDefaultDir + "/civicrm.settings.php"},
+	{".env", targetFile},
+	{"Caddyfile", caddyfile},
+}
+
+// siteManifest 是封存檔內的 manifest.json
+type siteManifest struct {
+	Format           string            `json:"format"`
+	FormatVersion    int               `json:"format_version"`
+	CreatedAt        time.Time         `json:"created_at"`
+	InstallerVersion string            `json:"installer_version"`
+	NeticrmVersion   string            `json:"neticrm_version"`
+	Images           map[string]string `json:"images"`
+	Database         archiveDBInfo     `json:"database"`
+	Encryption       *backupEncryption `json:"encryption,omitempty"`
+	Files            []manifestFile    `json:"files"`
+}
+
+type archiveDBInfo struct {
+	Name   string `json:"name"`
+	Tables int    `json:"tables"`
+}
+
+// manifestFile 記錄封存檔內每個一般檔案的大小與雜湊
+type manifestFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// createSiteArchive 建立包含資料庫、上傳檔案與設定檔的 .tar.gz 封存檔，enc 不為 nil 時整個封存檔以 age 加密
+func createSiteArchive(env map[string]string, path string, enc *backupEncryptor) (*siteManifest, error) {
+	database := envOrDefault(env, "MYSQL_DATABASE", "neticrmdb")
+
+	// tar 需要先知道檔案大小，因此先將資料庫匯出到暫存檔
+	dumpFile, err := os.CreateTemp(filepath.Dir(path), ".neticrm-dump-*.sql")
+	if err != nil {
+		return nil, fmt.Errorf("無法建立暫存檔: %w", err)
+	}
+	defer os.Remove(dumpFile.Name())
+	defer dumpFile.Close()
+
+	tables := &createTableCounter{atLineStart: true}
+	buffered := bufio.NewWriter(dumpFile)
+	if err := dumpDatabase(env, database, io.MultiWriter(buffered, tables)); err != nil {
+		return nil, err
+	}
+	if err := buffered.Flush(); err != nil {
+		return nil, fmt.Errorf("寫入暫存檔失敗: %w", err)
+	}
+
+	partial := path + ".partial"
+	out, err := os.OpenFile(partial, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("無法建立封存檔: %w", err)
+	}
+	defer os.Remove(partial)
+	defer out.Close()
+
+	var sink io.WriteCloser = nopWriteCloser{out}
+	if enc != nil {
+		if sink, err = enc.encrypt(out); err != nil {
+			return nil, err
+		}
+	}
+	gz := gzip.NewWriter(sink)
+	tw := tar.NewWriter(gz)
+
+	manifest := &siteManifest{
+		Format:           archiveFormat,
+		FormatVersion:    archiveFormatVersion,
+		CreatedAt:        time.Now(),
+		InstallerVersion: version,
+		NeticrmVersion:   detectNeticrmVersion(),
+		Images:           composeImages(currentComposeFile()),
+		Database:         archiveDBInfo{Name: database, Tables: tables.count},
+	}
+	if enc != nil {
+		manifest.Encryption = enc.info
+	}
+
+	if err := addFileToArchive(tw, manifest, dumpFile.Name(), archiveDatabase); err != nil {
+		return nil, err
+	}
+
+	for _, cf := range siteConfigFiles {
+		if !fileExists(cf.path) {
+			continue
+		}
+		if err := addFileToArchive(tw, manifest, cf.path, archiveConfigDir+"/"+cf.name); err != nil {
+			return nil, err
+		}
+	}
+
+	if fileExists(siteFilesDir) {
+		if err := addTreeToArchive(tw, manifest, siteFilesDir, archiveFilesDir); err != nil {
+			return nil, err
+		}
+	}
+
+	// manifest.json 放在最後，才能包含所有檔案的雜湊
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	hdr := &tar.Header{
+		Name:    archiveManifest,
+		Mode:    0600,
+		Size:    int64(len(data)),
+		ModTime: manifest.CreatedAt,
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return nil, err
+	}
+	if _, err := tw.Write(data); err != nil {
+		return nil, err
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, fmt.Errorf("寫入封存檔失敗: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return nil, fmt.Errorf("寫入封存檔失敗: %w", err)
+	}
+	if err := sink.Close(); err != nil {
+		return nil, fmt.Errorf("加密封存檔失敗: %w", err)
+	}
+	if err := out.Sync(); err != nil {
+		return nil, fmt.Errorf("寫入封存檔失敗: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		return nil, fmt.Errorf("寫入封存檔失敗: %w", err)
+	}
+	if err := os.Rename(partial, path); err != nil {
+		return nil, fmt.Errorf("無法完成封存檔: %w", err)
+	}
+
+	return manifest, nil
+}
+
+// addFileToArchive 將一般檔案寫入封存檔並記錄雜湊
+func addFileToArchive(tw *tar.Writer, manifest *siteManifest, src, name string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("無法讀取 %s: %w", src, err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	hdr, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+
+	if err := tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("寫入 %s 失敗: %w", name, err)
+	}
+
+	hash := sha256.New()
+	n, err := io.CopyN(io.MultiWriter(tw, hash), f, info.Size())
+	if err != nil {
+		return fmt.Errorf("寫入 %s 失敗（檔案是否在備份期間被修改？）: %w", name, err)
+	}
+
+	manifest.Files = append(manifest.Files, manifestFile{
+		Path:   name,
+		Size:   n,
+		SHA256: hex.EncodeToString(hash.Sum(nil)),
+	})
+	return nil
+}
+
+// addTreeToArchive 將整個目錄寫入封存檔的 prefix 之下
+func addTreeToArchive(tw *tar.Writer, manifest *siteManifest, root, prefix string) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		name := prefix
+		if rel != "." {
+			name = prefix + "/" + filepath.ToSlash(rel)
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case info.Mode().IsRegular():
+			return addFileToArchive(tw, manifest, path, name)
+		case info.IsDir():
+			hdr, err := tar.FileInfoHeader(info, "")
+			if err != nil {
+				return err
+			}
+			hdr.Name = name + "/"
+			return tw.WriteHeader(hdr)
+		case info.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			hdr, err := tar.FileInfoHeader(info, target)
+			if err != nil {
+				return err
+			}
+			hdr.Name = name
+			return tw.WriteHeader(hdr)
+		}
+
+		// socket、裝置檔等不納入備份
+		return nil
+	})
+}
+
+// detectNeticrmVersion 讀取已安裝 netiCRM 的版本
+func detectNeticrmVersion() string {
+	if data, err := os.ReadFile(civicrmDir + "/civicrm-version.txt"); err == nil {
+		if fields := strings.Fields(string(data)); len(fields) > 0 {
+			return fields[0]
+		}
+	}
+	// 模組尚未下載時以 .env 鎖定的版本為準
+	if env, err := envfile.ReadFile(targetFile); err == nil {
+		return env["NETICRM_VERSION"]
+	}
+	return ""
+}
+
+// composeImages 從 compose 檔案取出各服務使用的映像檔
+func composeImages(composeFile string) map[string]string {
+	images := make(map[string]string)
+
+	data, err := os.ReadFile(composeFile)
+	if err != nil {
+		return images
+	}
+
+	inServices := false
+	service := ""
+	for _, line := range strings.Split(string(data), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+		switch {
+		case indent == 0:
+			inServices = trimmed == "services:"
+			service = ""
+		case inServices && indent == 2 && strings.HasSuffix(trimmed, ":"):
+			service = strings.TrimSuffix(trimmed, ":")
+		case inServices && service != "" && strings.HasPrefix(trimmed, "image:"):
+			images[service] = strings.Trim(strings.TrimSpace(strings.TrimPrefix(trimmed, "image:")), `"'`)
+		}
+	}
+
+	return images
+}
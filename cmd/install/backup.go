@@ -0,0 +1,399 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/netivism/neticrm-selfhost/internal/envfile"
+)
+
+const (
+	backupDir       = "backups"
+	backupTimestamp = "20060102-150405"
+)
+
+// dbDumpManifest 記錄資料庫備份的內容摘要
+type dbDumpManifest struct {
+	CreatedAt time.Time `json:"created_at"`
+	Database  string    `json:"database"`
+	File      string    `json:"file"`
+	Size      int64     `json:"size"`
+	SHA256    string    `json:"sha256"`
+	Tables    int       `json:"tables"`
+
+	Encryption *backupEncryption `json:"encryption,omitempty"`
+}
+
+// siteBackupRecord 是封存檔旁的說明檔，讓使用者不必解開封存檔即可得知內容
+type siteBackupRecord struct {
+	File     string        `json:"file"`
+	Size     int64         `json:"size"`
+	SHA256   string        `json:"sha256"`
+	Manifest *siteManifest `json:"manifest"`
+}
+
+func runBackup(args []string) (err error) {
+	fs := newFlagSet("backup")
+	root := fs.String("dir", backupDir, "備份目錄，每次備份會在其下建立一個備份組")
+	reason := fs.String("reason", reasonManual, "記錄在備份組中的備份原因")
+	dbOnly := fs.Bool("db-only", false, "只備份資料庫（.sql.gz），不建立完整網站封存檔")
+	targetFlag := fs.String("target", "", "上傳的備份目的地（預設使用 .env 的 BACKUP_TARGET）")
+	noUpload := fs.Bool("no-upload", false, "只保留在本機，不上傳")
+	allowUnencrypted := fs.Bool("allow-unencrypted", false, "未設定加密時仍上傳備份")
+	var policy retentionPolicy
+	fs.IntVar(&policy.keepLast, "keep-last", 0, "備份成功後，同一原因的備份組保留最新的 N 個")
+	fs.IntVar(&policy.keepDaily, "keep-daily", 0, "備份成功後，同一原因的備份組保留最近 N 天每天一個")
+	fs.IntVar(&policy.keepWeekly, "keep-weekly", 0, "備份成功後，同一原因的備份組保留最近 N 週每週一個")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := noArgs(fs); err != nil {
+		return err
+	}
+
+	// 記錄本次執行結果，供 backup schedule status 與監控程式讀取
+	run := &backupRunStatus{StartedAt: time.Now(), Reason: *reason}
+	defer func() {
+		recordBackupRun(*root, run, err)
+	}()
+
+	if policy.keepLast < 0 || policy.keepDaily < 0 || policy.keepWeekly < 0 {
+		return newUsageError("保留數量不可為負數")
+	}
+	if !validBackupReason(*reason) {
+		return newUsageError("備份原因只能包含英數字與 -：%q", *reason)
+	}
+	if err := requireInstalled(); err != nil {
+		return err
+	}
+	if err := checkDocker(); err != nil {
+		return err
+	}
+
+	env, err := envfile.ReadFile(targetFile)
+	if err != nil {
+		return fmt.Errorf("讀取 %s 失敗: %w", targetFile, err)
+	}
+
+	enc, err := newBackupEncryptor(env)
+	if err != nil {
+		return err
+	}
+
+	// 先建立目的地，設定錯誤時不必等備份完成才發現
+	var target backupTarget
+	if spec := backupTargetSpec(*targetFlag, env); spec != "" && !*noUpload {
+		if target, err = newBackupTarget(spec, env); err != nil {
+			return err
+		}
+		defer target.Close()
+		run.Target = target.String()
+		if enc == nil {
+			if !*allowUnencrypted {
+				return unencryptedUploadError(target.String())
+			}
+			yellow.Println("⚠️  備份未加密，上傳前建議在 .env 設定 BACKUP_AGE_RECIPIENTS 或 BACKUP_AGE_PASSPHRASE")
+		}
+	}
+
+	set, err := newBackupSet(*root, *reason)
+	if err != nil {
+		return err
+	}
+	run.SetID = set.ID
+	if enc != nil {
+		set.Encryption = enc.info
+		if err := set.save(); err != nil {
+			return err
+		}
+	}
+
+	stamp := set.CreatedAt.Format(backupTimestamp)
+	if *dbOnly {
+		err = backupDatabaseOnly(env, set, "neticrm-db-"+stamp, enc)
+	} else {
+		err = backupSite(env, set, "neticrm-site-"+stamp, enc)
+	}
+	if err != nil {
+		// 失敗的備份組不保留，避免被當成可用的備份
+		set.remove()
+		return err
+	}
+
+	fmt.Printf("備份組：%s\n", set.dir)
+
+	if target != nil {
+		if err := uploadBackupSet(set, target); err != nil {
+			return fmt.Errorf("%w\n本機備份已保留，可執行 %s backup upload %s 續傳", err, programName(), set.ID)
+		}
+		run.Uploaded = true
+	}
+
+	if policy.keepLast+policy.keepDaily+policy.keepWeekly > 0 {
+		if run.Pruned, err = pruneBackupSets(*root, *reason, policy); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backupSite 在備份組中建立完整網站封存檔與說明檔
+func backupSite(env map[string]string, set *backupSet, name string, enc *backupEncryptor) error {
+	file := name + ".tar.gz"
+	if enc != nil {
+		file += encryptedSuffix
+	}
+	archivePath := set.path(file)
+	cyan.Printf("開始備份網站至 %s ...\n", archivePath)
+
+	manifest, err := createSiteArchive(env, archivePath, enc)
+	if err != nil {
+		return err
+	}
+
+	sum, size, err := sha256File(archivePath)
+	if err != nil {
+		return err
+	}
+	record := &siteBackupRecord{
+		File:     filepath.Base(archivePath),
+		Size:     size,
+		SHA256:   sum,
+		Manifest: manifest,
+	}
+	if err := writeJSONFile(set.path(name+".json"), record); err != nil {
+		return fmt.Errorf("寫入備份說明檔失敗: %w", err)
+	}
+	if err := set.addFile(file); err != nil {
+		return err
+	}
+	if err := set.addFile(name + ".json"); err != nil {
+		return err
+	}
+
+	green.Printf("✅ 網站備份完成：%s（%d 個檔案，資料庫 %d 個資料表，%d bytes）\n", archivePath, len(manifest.Files), manifest.Database.Tables, size)
+	fmt.Printf("SHA-256: %s\n", sum)
+	if enc != nil {
+		fmt.Printf("加密：%s\n", enc.info.describe())
+	}
+	return nil
+}
+
+// backupDatabaseOnly 只匯出資料庫並寫入清單
+func backupDatabaseOnly(env map[string]string, set *backupSet, name string, enc *backupEncryptor) error {
+	file := name + ".sql.gz"
+	if enc != nil {
+		file += encryptedSuffix
+	}
+	cyan.Printf("開始備份資料庫至 %s ...\n", set.path(file))
+
+	manifest, err := dumpDatabaseToFile(env, set.path(file), enc)
+	if err != nil {
+		return err
+	}
+
+	if err := writeJSONFile(set.path(name+".json"), manifest); err != nil {
+		return fmt.Errorf("寫入備份清單失敗: %w", err)
+	}
+	if err := set.addFile(file); err != nil {
+		return err
+	}
+	if err := set.addFile(name + ".json"); err != nil {
+		return err
+	}
+
+	green.Printf("✅ 資料庫備份完成：%s（%d 個資料表，%d bytes）\n", manifest.File, manifest.Tables, manifest.Size)
+	fmt.Printf("SHA-256: %s\n", manifest.SHA256)
+	if enc != nil {
+		fmt.Printf("加密：%s\n", enc.info.describe())
+	}
+	return nil
+}
+
+func validBackupReason(reason string) bool {
+	if reason == "" {
+		return false
+	}
+	for _, r := range reason {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// dumpDatabaseToFile 將 mariadb-dump 的輸出以 gzip 壓縮（enc 不為 nil 時再加密）寫入 path，完成後才改為正式檔名
+func dumpDatabaseToFile(env map[string]string, path string, enc *backupEncryptor) (*dbDumpManifest, error) {
+	partial := path + ".partial"
+	f, err := os.OpenFile(partial, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("無法建立備份檔: %w", err)
+	}
+	defer os.Remove(partial)
+	defer f.Close()
+
+	hash := sha256.New()
+	size := &countingWriter{}
+	var sink io.WriteCloser = nopWriteCloser{io.MultiWriter(f, hash, size)}
+	if enc != nil {
+		if sink, err = enc.encrypt(io.MultiWriter(f, hash, size)); err != nil {
+			return nil, err
+		}
+	}
+	gz := gzip.NewWriter(sink)
+
+	database := envOrDefault(env, "MYSQL_DATABASE", "neticrmdb")
+	tables := &createTableCounter{atLineStart: true}
+	if err := dumpDatabase(env, database, io.MultiWriter(gz, tables)); err != nil {
+		return nil, err
+	}
+
+	if err := gz.Close(); err != nil {
+		return nil, fmt.Errorf("壓縮備份檔失敗: %w", err)
+	}
+	if err := sink.Close(); err != nil {
+		return nil, fmt.Errorf("加密備份檔失敗: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		return nil, fmt.Errorf("寫入備份檔失敗: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return nil, fmt.Errorf("寫入備份檔失敗: %w", err)
+	}
+	if err := os.Rename(partial, path); err != nil {
+		return nil, fmt.Errorf("無法完成備份檔: %w", err)
+	}
+
+	manifest := &dbDumpManifest{
+		CreatedAt: time.Now(),
+		Database:  database,
+		File:      filepath.Base(path),
+		Size:      size.n,
+		SHA256:    hex.EncodeToString(hash.Sum(nil)),
+		Tables:    tables.count,
+	}
+	if enc != nil {
+		manifest.Encryption = enc.info
+	}
+	return manifest, nil
+}
+
+// dumpDatabase 在 neticrm-mariadb 容器內以一致性快照匯出資料庫，密碼經由環境變數傳遞而不出現在命令列
+func dumpDatabase(env map[string]string, database string, w io.Writer) error {
+	if !containerRunning(mariadbContainer) {
+		return fmt.Errorf("容器 %s 未執行，請先執行 %s start", mariadbContainer, programName())
+	}
+
+	cmd := exec.Command("docker", "exec", "-e", "MYSQL_PWD", mariadbContainer,
+		"mariadb-dump",
+		"--user=root",
+		"--single-transaction",
+		"--quick",
+		"--routines",
+		"--triggers",
+		"--events",
+		"--hex-blob",
+		"--default-character-set=utf8mb4",
+		database,
+	)
+	cmd.Env = append(os.Environ(), "MYSQL_PWD="+env["MYSQL_ROOT_PASSWORD"])
+	cmd.Stdout = w
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("mariadb-dump 失敗: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// containerRunning 檢查指定名稱的容器是否正在執行
+func containerRunning(name string) bool {
+	out, err := exec.Command("docker", "inspect", "-f", "{{.State.Running}}", name).Output()
+	return err == nil && strings.TrimSpace(string(out)) == "true"
+}
+
+func envOrDefault(env map[string]string, key, fallback string) string {
+	if v := env[key]; v != "" {
+		return v
+	}
+	return fallback
+}
+
+// sha256File 計算檔案的 SHA-256 與大小
+func sha256File(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	n, err := io.Copy(hash, f)
+	if err != nil {
+		return "", 0, fmt.Errorf("讀取 %s 失敗: %w", path, err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), n, nil
+}
+
+func writeJSONFile(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0600)
+}
+
+// countingWriter 計算寫入的位元組數
+type countingWriter struct {
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
+
+// createTableCounter 計算 SQL 串流中以 CREATE TABLE 開頭的行數
+type createTableCounter struct {
+	count       int
+	atLineStart bool
+	matched     int
+}
+
+var createTablePrefix = []byte("CREATE TABLE ")
+
+func (c *createTableCounter) Write(p []byte) (int, error) {
+	for _, b := range p {
+		if b == '\n' {
+			c.atLineStart = true
+			c.matched = 0
+			continue
+		}
+		if !c.atLineStart {
+			continue
+		}
+		if b == createTablePrefix[c.matched] {
+			c.matched++
+			if c.matched == len(createTablePrefix) {
+				c.count++
+				c.atLineStart = false
+				c.matched = 0
+			}
+			continue
+		}
+		c.atLineStart = false
+		c.matched = 0
+	}
+	return len(p), nil
+}
@@ -0,0 +1,437 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/AlecAivazis/survey/v2"
+)
+
+const backupSetMetadata = "backup.json"
+
+// 備份組的原因
+const (
+	reasonInstall      = "install"
+	reasonOverwrite    = "overwrite"
+	reasonManual       = "manual"
+	reasonRestore      = "pre-restore"
+	reasonScheduled    = "scheduled"
+	reasonUpgrade      = "pre-upgrade"
+	reasonReconfigure  = "reconfigure"
+	reasonRotateDB     = "pre-rotate-db"
+	reasonChangeDomain = "change-domain"
+)
+
+// backupSet 是一次備份操作產生的目錄 backups/<時間>-<原因>/，同一次操作備份的項目放在一起
+type backupSet struct {
+	ID        string       `json:"id"`
+	Reason    string       `json:"reason"`
+	CreatedAt time.Time    `json:"created_at"`
+	Items     []backupItem `json:"items"`
+	// Encryption 記錄備份組內封存檔的加密方式，未加密時為 nil
+	Encryption *backupEncryption `json:"encryption,omitempty"`
+
+	dir string
+}
+
+// backupItem 記錄備份組內的一個項目
+type backupItem struct {
+	// Source 為原本位於網站目錄中的相對路徑，封存檔等新建立的檔案則為空
+	Source string `json:"source,omitempty"`
+	// Path 為相對於備份組目錄的路徑；External 為 true 時則是相對於網站目錄
+	Path     string `json:"path"`
+	External bool   `json:"external,omitempty"`
+}
+
+var (
+	pendingBackupReason = reasonInstall
+	runBackupSet        *backupSet
+)
+
+// beginBackupSet 設定本次操作的備份原因，之後 backupFile 移走的項目都會放在同一個備份組
+func beginBackupSet(reason string) {
+	pendingBackupReason = reason
+	runBackupSet = nil
+}
+
+// currentBackupSet 回傳本次操作的備份組，第一次使用時才建立目錄
+func currentBackupSet() (*backupSet, error) {
+	if runBackupSet != nil {
+		return runBackupSet, nil
+	}
+	set, err := newBackupSet(backupDir, pendingBackupReason)
+	if err != nil {
+		return nil, err
+	}
+	runBackupSet = set
+	return set, nil
+}
+
+// newBackupSet 在 root 下建立新的備份組目錄
+func newBackupSet(root, reason string) (*backupSet, error) {
+	now := time.Now()
+	id := now.Format(backupTimestamp) + "-" + reason
+
+	if err := os.MkdirAll(root, 0700); err != nil {
+		return nil, fmt.Errorf("無法建立備份目錄 %s: %w", root, err)
+	}
+	dir := filepath.Join(root, id)
+	for n := 2; ; n++ {
+		err := os.Mkdir(dir, 0700)
+		if err == nil {
+			break
+		}
+		if !os.IsExist(err) {
+			return nil, fmt.Errorf("無法建立備份組 %s: %w", dir, err)
+		}
+		id = fmt.Sprintf("%s-%s-%d", now.Format(backupTimestamp), reason, n)
+		dir = filepath.Join(root, id)
+	}
+
+	set := &backupSet{ID: id, Reason: reason, CreatedAt: now, dir: dir}
+	return set, set.save()
+}
+
+func (s *backupSet) save() error {
+	return writeJSONFile(filepath.Join(s.dir, backupSetMetadata), s)
+}
+
+// moveIn 將網站目錄中的檔案或目錄移入備份組，回傳新位置
+func (s *backupSet) moveIn(src string) (string, error) {
+	rel := filepath.Clean(src)
+	dest := filepath.Join(s.dir, rel)
+	item := backupItem{Source: filepath.ToSlash(rel), Path: filepath.ToSlash(rel)}
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0700); err != nil {
+		return "", err
+	}
+	err := os.Rename(src, dest)
+	if errors.Is(err, syscall.EXDEV) {
+		// 備份目錄與資料不在同一個檔案系統時，改名留在原位置旁
+		dest = src + ".bak-" + s.ID
+		item.Path = filepath.ToSlash(filepath.Clean(dest))
+		item.External = true
+		err = os.Rename(src, dest)
+	}
+	if err != nil {
+		return "", fmt.Errorf("無法備份 %s: %v", src, err)
+	}
+
+	s.Items = append(s.Items, item)
+	return dest, s.save()
+}
+
+// copyIn 將網站目錄中的檔案複製到備份組，原檔保留不動
+func (s *backupSet) copyIn(src string) (string, error) {
+	rel := filepath.Clean(src)
+	dest := filepath.Join(s.dir, rel)
+
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return "", fmt.Errorf("無法備份 %s: %v", src, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0700); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(dest, data, 0600); err != nil {
+		return "", fmt.Errorf("無法備份 %s: %v", src, err)
+	}
+
+	s.Items = append(s.Items, backupItem{Source: filepath.ToSlash(rel), Path: filepath.ToSlash(rel)})
+	return dest, s.save()
+}
+
+// addFile 記錄在備份組目錄中新建立的檔案
+func (s *backupSet) addFile(name string) error {
+	s.Items = append(s.Items, backupItem{Path: filepath.ToSlash(name)})
+	return s.save()
+}
+
+// path 回傳備份組目錄中的檔案路徑
+func (s *backupSet) path(name string) string {
+	return filepath.Join(s.dir, name)
+}
+
+// size 計算備份組佔用的空間
+func (s *backupSet) size() int64 {
+	var total int64
+	add := func(root string) {
+		filepath.WalkDir(root, func(_ string, d fs.DirEntry, err error) error {
+			if err == nil && !d.IsDir() {
+				if info, err := d.Info(); err == nil {
+					total += info.Size()
+				}
+			}
+			return nil
+		})
+	}
+	add(s.dir)
+	for _, item := range s.Items {
+		if item.External {
+			add(item.Path)
+		}
+	}
+	return total
+}
+
+// remove 刪除備份組，包含移到原位置旁的項目
+func (s *backupSet) remove() error {
+	for _, item := range s.Items {
+		if !item.External {
+			continue
+		}
+		// 只刪除確實由此備份組產生的路徑
+		if !strings.HasSuffix(item.Path, ".bak-"+s.ID) {
+			return fmt.Errorf("備份組 %s 的項目 %s 路徑不符，拒絕刪除", s.ID, item.Path)
+		}
+		if err := os.RemoveAll(item.Path); err != nil {
+			return err
+		}
+	}
+	return os.RemoveAll(s.dir)
+}
+
+// listBackupSets 讀取 root 下所有備份組，由新到舊排序
+func listBackupSets(root string) ([]*backupSet, error) {
+	entries, err := os.ReadDir(root)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var sets []*backupSet
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		dir := filepath.Join(root, e.Name())
+		data, err := os.ReadFile(filepath.Join(dir, backupSetMetadata))
+		if err != nil {
+			continue
+		}
+		set := &backupSet{}
+		if err := json.Unmarshal(data, set); err != nil || set.ID != e.Name() {
+			yellow.Printf("⚠️  略過無法辨識的備份組 %s\n", dir)
+			continue
+		}
+		set.dir = dir
+		sets = append(sets, set)
+	}
+
+	sort.Slice(sets, func(i, j int) bool {
+		return sets[i].CreatedAt.After(sets[j].CreatedAt)
+	})
+	return sets, nil
+}
+
+// retentionPolicy 決定保留哪些備份組
+type retentionPolicy struct {
+	keepLast   int
+	keepDaily  int
+	keepWeekly int
+}
+
+// apply 將由新到舊排序的備份組分為保留與刪除，並回傳保留原因
+func (p retentionPolicy) apply(sets []*backupSet) (keep map[string][]string, remove []*backupSet) {
+	keep = make(map[string][]string)
+	days := make(map[string]bool)
+	weeks := make(map[string]bool)
+
+	for i, set := range sets {
+		t := set.CreatedAt.Local()
+
+		if i < p.keepLast {
+			keep[set.ID] = append(keep[set.ID], "last")
+		}
+
+		day := t.Format("2006-01-02")
+		if len(days) < p.keepDaily && !days[day] {
+			days[day] = true
+			keep[set.ID] = append(keep[set.ID], "daily "+day)
+		}
+
+		year, wk := t.ISOWeek()
+		week := fmt.Sprintf("%d-W%02d", year, wk)
+		if len(weeks) < p.keepWeekly && !weeks[week] {
+			weeks[week] = true
+			keep[set.ID] = append(keep[set.ID], "weekly "+week)
+		}
+
+		if len(keep[set.ID]) == 0 {
+			remove = append(remove, set)
+		}
+	}
+
+	return keep, remove
+}
+
+func runBackupList(args []string) error {
+	fs := newFlagSet("backup list")
+	root := fs.String("dir", backupDir, "備份目錄")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := noArgs(fs); err != nil {
+		return err
+	}
+
+	sets, err := listBackupSets(*root)
+	if err != nil {
+		return err
+	}
+	if len(sets) == 0 {
+		fmt.Printf("%s 中沒有備份。\n", *root)
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\t時間\t原因\t大小\t內容")
+	for _, set := range sets {
+		var items []string
+		for _, item := range set.Items {
+			if item.Source != "" {
+				items = append(items, item.Source)
+			} else {
+				items = append(items, filepath.Base(item.Path))
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+			set.ID,
+			set.CreatedAt.Local().Format("2006-01-02 15:04:05"),
+			set.Reason,
+			formatBytes(set.size()),
+			strings.Join(items, ", "))
+	}
+	return w.Flush()
+}
+
+func runBackupPrune(args []string) error {
+	fs := newFlagSet("backup prune")
+	root := fs.String("dir", backupDir, "備份目錄")
+	reason := fs.String("reason", reasonManual, "只處理此原因的備份組，例如 manual 或 scheduled")
+	var policy retentionPolicy
+	fs.IntVar(&policy.keepLast, "keep-last", 0, "保留最新的 N 個備份組")
+	fs.IntVar(&policy.keepDaily, "keep-daily", 0, "保留最近 N 天每天最新的一個備份組")
+	fs.IntVar(&policy.keepWeekly, "keep-weekly", 0, "保留最近 N 週每週最新的一個備份組")
+	dryRun := fs.Bool("dry-run", false, "只列出將刪除的備份組，不實際刪除")
+	yes := fs.Bool("yes", false, "不詢問確認，直接刪除")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := noArgs(fs); err != nil {
+		return err
+	}
+	if policy.keepLast < 0 || policy.keepDaily < 0 || policy.keepWeekly < 0 {
+		return newUsageError("保留數量不可為負數")
+	}
+	if policy.keepLast+policy.keepDaily+policy.keepWeekly == 0 {
+		return newUsageError("請至少指定 --keep-last、--keep-daily 或 --keep-weekly 其中之一，避免刪除所有備份")
+	}
+
+	all, err := listBackupSets(*root)
+	if err != nil {
+		return err
+	}
+	// 安裝、覆蓋或還原前移開的資料只存在於各自的備份組，不與一般備份一起套用保留原則
+	sets := setsWithReason(all, *reason)
+	if len(sets) == 0 {
+		fmt.Printf("%s 中沒有原因為 %s 的備份組。\n", *root, *reason)
+		return nil
+	}
+
+	keep, remove := policy.apply(sets)
+	for _, set := range sets {
+		if reasons, ok := keep[set.ID]; ok {
+			green.Printf("  保留 %s（%s）\n", set.ID, strings.Join(reasons, "、"))
+		} else {
+			red.Printf("  刪除 %s（%s）\n", set.ID, formatBytes(set.size()))
+		}
+	}
+
+	if len(remove) == 0 {
+		fmt.Println("沒有需要刪除的備份組。")
+		return nil
+	}
+	if *dryRun {
+		cyan.Printf("預覽模式：將刪除 %d 個備份組，未實際刪除。\n", len(remove))
+		return nil
+	}
+
+	if !*yes {
+		confirm := false
+		prompt := &survey.Confirm{
+			Message: fmt.Sprintf("確定要刪除以上 %d 個備份組嗎？", len(remove)),
+			Default: false,
+		}
+		if err := survey.AskOne(prompt, &confirm); err != nil {
+			return err
+		}
+		if !confirm {
+			fmt.Println("已取消。")
+			return nil
+		}
+	}
+
+	for _, set := range remove {
+		if err := set.remove(); err != nil {
+			return fmt.Errorf("刪除備份組 %s 失敗: %w", set.ID, err)
+		}
+	}
+	green.Printf("✅ 已刪除 %d 個備份組\n", len(remove))
+	return nil
+}
+
+// pruneBackupSets 在備份完成後套用保留原則，只處理同一原因的備份組，
+// 避免刪除安裝或還原前自動建立的備份
+func pruneBackupSets(root, reason string, policy retentionPolicy) ([]string, error) {
+	sets, err := listBackupSets(root)
+	if err != nil {
+		return nil, err
+	}
+
+	_, remove := policy.apply(setsWithReason(sets, reason))
+	var removed []string
+	for _, set := range remove {
+		if err := set.remove(); err != nil {
+			return removed, fmt.Errorf("刪除備份組 %s 失敗: %w", set.ID, err)
+		}
+		fmt.Printf("  已刪除舊的備份組 %s\n", set.ID)
+		removed = append(removed, set.ID)
+	}
+	return removed, nil
+}
+
+// setsWithReason 回傳原因為 reason 的備份組，順序不變
+func setsWithReason(sets []*backupSet, reason string) []*backupSet {
+	var same []*backupSet
+	for _, set := range sets {
+		if set.Reason == reason {
+			same = append(same, set)
+		}
+	}
+	return same
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// retentionSets 回傳由新到舊排序、時間固定的備份組
+func retentionSets() []*backupSet {
+	at := func(id string, month time.Month, day, hour int) *backupSet {
+		year := 2026
+		if month == time.December {
+			year = 2025
+		}
+		return &backupSet{ID: id, Reason: reasonScheduled, CreatedAt: time.Date(year, month, day, hour, 0, 0, 0, time.Local)}
+	}
+	return []*backupSet{
+		at("a", time.January, 15, 20), // 2026-W03
+		at("b", time.January, 15, 9),  // 與 a 同一天
+		at("c", time.January, 14, 3),  // 2026-W03
+		at("d", time.January, 11, 3),  // 週日，2026-W02
+		at("e", time.January, 5, 3),   // 週一，2026-W02
+		at("f", time.January, 1, 3),   // 2026-W01 由 2025-12-29 開始
+		at("g", time.December, 28, 3), // 2025-W52
+	}
+}
+
+func TestRetentionPolicyApply(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  retentionPolicy
+		removed []string
+		keep    map[string][]string
+	}{
+		{
+			name:    "keep last",
+			policy:  retentionPolicy{keepLast: 2},
+			removed: []string{"c", "d", "e", "f", "g"},
+			keep:    map[string][]string{"a": {"last"}, "b": {"last"}},
+		},
+		{
+			name:    "daily keeps the newest set of each day",
+			policy:  retentionPolicy{keepDaily: 2},
+			removed: []string{"b", "d", "e", "f", "g"},
+			keep:    map[string][]string{"a": {"daily 2026-01-15"}, "c": {"daily 2026-01-14"}},
+		},
+		{
+			name:    "weekly uses ISO weeks across the year boundary",
+			policy:  retentionPolicy{keepWeekly: 4},
+			removed: []string{"b", "c", "e"},
+			keep: map[string][]string{
+				"a": {"weekly 2026-W03"},
+				"d": {"weekly 2026-W02"},
+				"f": {"weekly 2026-W01"},
+				"g": {"weekly 2025-W52"},
+			},
+		},
+		{
+			name:    "overlapping daily and weekly buckets",
+			policy:  retentionPolicy{keepDaily: 3, keepWeekly: 2},
+			removed: []string{"b", "e", "f", "g"},
+			keep: map[string][]string{
+				"a": {"daily 2026-01-15", "weekly 2026-W03"},
+				"c": {"daily 2026-01-14"},
+				"d": {"daily 2026-01-11", "weekly 2026-W02"},
+			},
+		},
+		{
+			name:    "last, daily and weekly together",
+			policy:  retentionPolicy{keepLast: 2, keepDaily: 1, keepWeekly: 2},
+			removed: []string{"c", "e", "f", "g"},
+			keep: map[string][]string{
+				"a": {"last", "daily 2026-01-15", "weekly 2026-W03"},
+				"b": {"last"},
+				"d": {"weekly 2026-W02"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keep, remove := tt.policy.apply(retentionSets())
+			var removed []string
+			for _, set := range remove {
+				removed = append(removed, set.ID)
+			}
+			if !reflect.DeepEqual(removed, tt.removed) {
+				t.Errorf("removed = %v, want %v", removed, tt.removed)
+			}
+			if !reflect.DeepEqual(keep, tt.keep) {
+				t.Errorf("keep = %v, want %v", keep, tt.keep)
+			}
+		})
+	}
+}
+
+func TestSetsWithReason(t *testing.T) {
+	sets := []*backupSet{
+		{ID: "3", Reason: reasonManual},
+		{ID: "2", Reason: reasonOverwrite},
+		{ID: "1", Reason: reasonManual},
+	}
+	var ids []string
+	for _, set := range setsWithReason(sets, reasonManual) {
+		ids = append(ids, set.ID)
+	}
+	if want := []string{"3", "1"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("setsWithReason(manual) = %v, want %v", ids, want)
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/AlecAivazis/survey/v2"
+)
+
+// normalizeBind 移除 IPv6 位址的方括號；.env 的 HTTP_BIND 由 compose 的 host_ip 使用，不加方括號
+func normalizeBind(bind string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(bind), "["), "]")
+}
+
+// validBind 確認 HTTP_BIND 是本機的 IP 位址；空白代表所有網路介面
+func validBind(bind string) error {
+	if bind == "" {
+		return nil
+	}
+	ip := net.ParseIP(bind)
+	if ip == nil {
+		return fmt.Errorf("HTTP_BIND 必須是 IP 位址，例如 127.0.0.1 或 ::1，而非 %q", bind)
+	}
+	if ip.IsUnspecified() {
+		return nil
+	}
+	if ip.To4() == nil && ip.IsLinkLocalUnicast() {
+		return fmt.Errorf("HTTP_BIND %s 是 IPv6 link-local 位址，Docker 無法發布，請改用其他位址", bind)
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil
+	}
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.Equal(ip) {
+			return nil
+		}
+	}
+	return fmt.Errorf("HTTP_BIND %s 不是本機網路介面的位址", bind)
+}
+
+// interfaceAddress 為可以發布網站的網路介面位址
+type interfaceAddress struct {
+	iface string
+	ip    string
+}
+
+// localAddresses 列出本機已啟用網路介面的位址，不含 loopback 與 IPv6 link-local
+func localAddresses() []interfaceAddress {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+	var list []interfaceAddress
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			ipnet, ok := addr.(*net.IPNet)
+			if !ok || ipnet.IP.IsLinkLocalUnicast() {
+				continue
+			}
+			list = append(list, interfaceAddress{iface: iface.Name, ip: ipnet.IP.String()})
+		}
+	}
+	return list
+}
+
+// askHTTPBind 詢問 nginx 要在哪個位址發布 HTTP_PORT
+func askHTTPBind(cfg *Config) error {
+	type option struct {
+		label, bind string
+	}
+	options := []option{
+		{"1. All interfaces (0.0.0.0, Docker also publishes on IPv6)", ""},
+		{"2. Localhost only (127.0.0.1), for a reverse proxy on this host", "127.0.0.1"},
+		{"3. IPv6 localhost only (::1)", "::1"},
+		{"4. All IPv6 interfaces (::)", "::"},
+		{"5. A specific interface address", "interface"},
+	}
+	message := "Which address should the site listen on?"
+	ifaceMessage := "Choose the interface address:"
+	if cfg.Language == "zh-hant" {
+		options[0].label = "1. 所有網路介面（0.0.0.0，Docker 也會發布到 IPv6）"
+		options[1].label = "2. 只限本機（127.0.0.1），供同一台主機上的反向代理使用"
+		options[2].label = "3. 只限本機 IPv6（::1）"
+		options[3].label = "4. 所有 IPv6 網路介面（::）"
+		options[4].label = "5. 指定網路介面的位址"
+		message = "網站要監聽哪個位址？"
+		ifaceMessage = "請選擇網路介面的位址："
+	}
+
+	labels := make([]string, len(options))
+	for i, o := range options {
+		labels[i] = o.label
+	}
+	prompt := &survey.Select{Message: message, Options: labels, Default: labels[0]}
+
+	// 重新設定時預選目前的位址
+	addrs := localAddresses()
+	current := cfg.HTTPBind
+	for _, o := range options {
+		if o.bind == current && current != "" {
+			prompt.Default = o.label
+		}
+	}
+	for _, a := range addrs {
+		if a.ip == current {
+			prompt.Default = labels[4]
+		}
+	}
+
+	var choice string
+	if err := survey.AskOne(prompt, &choice); err != nil {
+		return err
+	}
+	for _, o := range options {
+		if o.label == choice && o.bind != "interface" {
+			cfg.HTTPBind = o.bind
+			return nil
+		}
+	}
+
+	if len(addrs) == 0 {
+		return fmt.Errorf("找不到可以使用的網路介面位址")
+	}
+	ifaceLabels := make([]string, len(addrs))
+	ifacePrompt := &survey.Select{Message: ifaceMessage, Options: ifaceLabels}
+	for i, a := range addrs {
+		ifaceLabels[i] = fmt.Sprintf("%s  %s", a.iface, a.ip)
+		if a.ip == current {
+			ifacePrompt.Default = ifaceLabels[i]
+		}
+	}
+	var picked int
+	if err := survey.AskOne(ifacePrompt, &picked); err != nil {
+		return err
+	}
+	cfg.HTTPBind = addrs[picked].ip
+	return nil
+}
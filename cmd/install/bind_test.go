@@ -0,0 +1,48 @@
+package main
+
+import "testing"
+
+func TestNormalizeBind(t *testing.T) {
+	tests := []struct {
+		bind string
+		want string
+	}{
+		{"", ""},
+		{"127.0.0.1", "127.0.0.1"},
+		{" 127.0.0.1 ", "127.0.0.1"},
+		{"::1", "::1"},
+		{"[::1]", "::1"},
+		{" [2001:db8::1] ", "2001:db8::1"},
+		{"[::]", "::"},
+	}
+	for _, tt := range tests {
+		if got := normalizeBind(tt.bind); got != tt.want {
+			t.Errorf("normalizeBind(%q) = %q, want %q", tt.bind, got, tt.want)
+		}
+	}
+}
+
+func TestValidBind(t *testing.T) {
+	tests := []struct {
+		bind    string
+		wantErr bool
+	}{
+		{"", false},
+		{"0.0.0.0", false},
+		{"::", false},
+		{"127.0.0.1", false},
+		{"fe80::1", true},
+		{"fe80::1%eth0", true},
+		{"[::1]", true},
+		{"localhost", true},
+		{"127.0.0.1:8080", true},
+		// TEST-NET-2，不會是本機網路介面的位址
+		{"198.51.100.7", true},
+	}
+	for _, tt := range tests {
+		err := validBind(tt.bind)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("validBind(%q) error = %v, wantErr %v", tt.bind, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/netivism/neticrm-selfhost/internal/envfile"
+)
+
+// 結束碼
+const (
+	exitOK      = 0
+	exitFailure = 1
+	exitUsage   = 2
+)
+
+// command 描述一個子命令，subcommands 為其下一層命令（例如 backup list）
+type command struct {
+	name        string
+	args        string
+	summary     string
+	run         func(args []string) error
+	subcommands []*command
+}
+
+// usageError 代表命令列參數錯誤，以 exitUsage 結束
+type usageError struct {
+	msg string
+}
+
+func (e usageError) Error() string { return e.msg }
+
+func newUsageError(format string, a ...interface{}) error {
+	return usageError{msg: fmt.Sprintf(format, a...)}
+}
+
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{name: "install", args: "[選項]", summary: "互動式安裝或重新設定網站（預設命令）", run: runInstall},
+		{name: "start", summary: "啟動網站服務（若已啟動則不影響）", run: runStart},
+		{name: "stop", summary: "停止並移除網站容器（資料保留在 data/）", run: runStop},
+		{name: "status", summary: "顯示目前設定與容器狀態", run: runStatus},
+		{name: "reconfigure", args: "[選項]", summary: "修改已安裝網站的設定", run: runReconfigure},
+		{name: "ssl", args: "[enable|disable] [選項]", summary: "切換 SSL（Caddy）與 HTTP 模式", run: runSSL, subcommands: []*command{
+			{name: "enable", args: "[--domain 網域] [--email 信箱] [--acme-ca CA] [--acme-ca-root 檔案] [--yes]", summary: "改由 Caddy 提供 HTTPS 並取得 Let's Encrypt 憑證", run: runSSLEnable},
+			{name: "disable", args: "[--port 埠] [--yes]", summary: "停用 Caddy，改由 nginx 在 HTTP_PORT 提供 HTTP", run: runSSLDisable},
+		}},
+		{name: "change-domain", args: "[--yes] <網域>", summary: "變更網域並同步更新 .env、Caddyfile、網站設定檔與 CiviCRM 網址", run: runChangeDomain},
+		{name: "rotate-db-credentials", args: "[選項]", summary: "更換資料庫密碼並同步更新 .env 與網站設定檔", run: runRotateDBCredentials},
+		{name: "backup", args: "[子命令] [選項]", summary: "備份資料庫、上傳檔案與設定為單一封存檔", run: runBackup, subcommands: []*command{
+			{name: "list", args: "[選項]", summary: "列出所有備份組", run: runBackupList},
+			{name: "prune", args: "[選項]", summary: "依保留原則刪除舊的備份組", run: runBackupPrune},
+			{name: "upload", args: "[選項] [備份組 ID]", summary: "上傳或續傳備份組到遠端目的地（預設最新的備份組）", run: runBackupUpload},
+			{name: "schedule", args: "[子命令] [選項]", summary: "安裝每日排程備份（systemd timer，無 systemd 時使用 crontab）", run: runBackupSchedule, subcommands: []*command{
+				{name: "status", args: "[選項]", summary: "顯示排程、下次執行時間與上次備份結果", run: runBackupScheduleStatus},
+				{name: "remove", summary: "移除此目錄的排程備份", run: runBackupScheduleRemove},
+			}},
+		}},
+		{name: "restore", args: "[選項] <封存檔>", summary: "由網站備份封存檔還原網站", run: runRestore},
+		{name: "upgrade", args: "[--to 版本] [選項]", summary: "先完整備份，再升級 netiCRM 並執行 drush updb", run: runUpgrade},
+		{name: "logs", args: "[選項] [服務...]", summary: "檢視容器日誌", run: runLogs},
+		{name: "login-link", args: "[--user 帳號]", summary: "產生管理員一次性登入連結", run: runLoginLink},
+		{name: "admin", args: "<子命令> [選項]", summary: "重設網站帳號密碼或解除登入鎖定", run: runAdmin, subcommands: []*command{
+			{name: "reset-password", args: "[--user 帳號] [--generate] [--update-env]", summary: "以 drush user:password 重設帳號密碼（預設為 ADMIN_LOGIN_USER）", run: runAdminResetPassword},
+			{name: "unlock", args: "[--user 帳號] [--reset-password [--generate] [--update-env]]", summary: "清除登入失敗紀錄並解除帳號封鎖", run: runAdminUnlock},
+		}},
+		{name: "doctor", args: "[--language 語言]", summary: "檢查主機環境是否可以安裝，並列出修正方式", run: runDoctor},
+	}
+}
+
+// runCLI 解析子命令並執行，回傳結束碼
+func runCLI(args []string) int {
+	name := "install"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	} else if len(args) > 0 && (args[0] == "-h" || args[0] == "-help" || args[0] == "--help") {
+		printUsage(os.Stdout)
+		return exitOK
+	}
+
+	if name == "help" {
+		if len(args) == 0 {
+			printUsage(os.Stdout)
+			return exitOK
+		}
+		name, args = args[0], []string{"-h"}
+	}
+
+	cmd := findCommand(name)
+	if cmd == nil {
+		red.Printf("✗ 未知的命令: %s\n\n", name)
+		printUsage(os.Stderr)
+		return exitUsage
+	}
+
+	// 逐層尋找子命令，例如 backup prune
+	path := cmd.name
+	for len(args) > 0 {
+		sub := findSubcommand(cmd.subcommands, args[0])
+		if sub == nil {
+			break
+		}
+		cmd, args = sub, args[1:]
+		path += " " + sub.name
+	}
+
+	err := cmd.run(args)
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.Is(err, flag.ErrHelp):
+		return exitOK
+	}
+
+	var ue usageError
+	if errors.As(err, &ue) {
+		red.Printf("✗ %v\n", err)
+		fmt.Fprintf(os.Stderr, "執行 %s %s -h 檢視用法。\n", programName(), path)
+		return exitUsage
+	}
+	var mfe missingFieldsError
+	if errors.As(err, &mfe) {
+		red.Printf("✗ %v\n", err)
+		return exitUsage
+	}
+
+	red.Printf("✗ %v\n", err)
+	return exitFailure
+}
+
+// findCommand 依名稱尋找命令，name 可包含子命令，例如 "backup prune"
+func findCommand(name string) *command {
+	var cmd *command
+	list := commands
+	for _, part := range strings.Fields(name) {
+		if cmd = findSubcommand(list, part); cmd == nil {
+			return nil
+		}
+		list = cmd.subcommands
+	}
+	return cmd
+}
+
+func findSubcommand(list []*command, name string) *command {
+	for _, c := range list {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func programName() string {
+	return filepath.Base(os.Args[0])
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintf(w, "netiCRM Self-Host 自架站台管理工具\n\n")
+	fmt.Fprintf(w, "用法: %s <命令> [選項]\n\n命令:\n", programName())
+	width := 0
+	for _, c := range commands {
+		width = max(width, len(c.name))
+	}
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-*s %s\n", width, c.name, c.summary)
+	}
+	fmt.Fprintf(w, "\n執行 %s <命令> -h 檢視各命令的選項。\n", programName())
+}
+
+// newFlagSet 建立子命令的 FlagSet，錯誤交由 runCLI 統一處理
+func newFlagSet(name string) *flag.FlagSet {
+	cmd := findCommand(name)
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		out := fs.Output()
+		fmt.Fprintf(out, "用法: %s %s %s\n\n%s\n", programName(), name, cmd.args, cmd.summary)
+		if len(cmd.subcommands) > 0 {
+			fmt.Fprintf(out, "\n子命令:\n")
+			width := 12
+			for _, sub := range cmd.subcommands {
+				width = max(width, len(sub.name))
+			}
+			for _, sub := range cmd.subcommands {
+				fmt.Fprintf(out, "  %-*s %s\n", width, sub.name, sub.summary)
+			}
+		}
+		hasFlags := false
+		fs.VisitAll(func(*flag.Flag) { hasFlags = true })
+		if hasFlags {
+			fmt.Fprintf(out, "\n選項:\n")
+			fs.PrintDefaults()
+		}
+	}
+	return fs
+}
+
+// parseFlags 解析旗標，並將解析錯誤轉為 usageError
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return usageError{msg: err.Error()}
+	}
+	return nil
+}
+
+func noArgs(fs *flag.FlagSet) error {
+	if fs.NArg() > 0 {
+		return newUsageError("%s 不接受參數: %s", fs.Name(), strings.Join(fs.Args(), " "))
+	}
+	return nil
+}
+
+// requireInstalled 確認目前目錄已有 .env，供日常維運命令使用
+func requireInstalled() error {
+	if !fileExists(targetFile) {
+		return fmt.Errorf("找不到 %s，請先在網站目錄執行 %s install", targetFile, programName())
+	}
+	return nil
+}
+
+// currentComposeFile 依現有設定判斷使用的 compose 檔案
+func currentComposeFile() string {
+	return composeFileFor(sslEnabled())
+}
+
+// dockerCompose 執行 docker compose 並將輸出導向終端機
+func dockerCompose(composeFile string, args ...string) error {
+	cmd := exec.Command("docker", append([]string{"compose", "-f", composeFile}, args...)...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Stdin = os.Stdin
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("執行 docker compose %s 失敗: %w", strings.Join(args, " "), err)
+	}
+	return nil
+}
+
+// dockerExec 在容器內執行命令並將輸出導向終端機
+func dockerExec(container string, args ...string) error {
+	cmd := exec.Command("docker", append([]string{"exec", container}, args...)...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	return cmd.Run()
+}
+
+func runStart(args []string) error {
+	fs := newFlagSet("start")
+	timeout := fs.Duration("timeout", defaultInitTimeout, "等待網站初始化完成的時間，0 表示啟動容器後不等待")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := noArgs(fs); err != nil {
+		return err
+	}
+	if err := requireInstalled(); err != nil {
+		return err
+	}
+	if err := checkDocker(); err != nil {
+		return err
+	}
+
+	return startDocker(*timeout)
+}
+
+func runStop(args []string) error {
+	fs := newFlagSet("stop")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := noArgs(fs); err != nil {
+		return err
+	}
+	if err := requireInstalled(); err != nil {
+		return err
+	}
+	if err := checkDocker(); err != nil {
+		return err
+	}
+
+	if err := dockerCompose(currentComposeFile(), "down"); err != nil {
+		return err
+	}
+	green.Println("網站已停止。")
+	return nil
+}
+
+func runStatus(args []string) error {
+	fs := newFlagSet("status")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := noArgs(fs); err != nil {
+		return err
+	}
+	if err := requireInstalled(); err != nil {
+		return err
+	}
+
+	existingEnv, err := envfile.ReadFile(targetFile)
+	if err != nil {
+		return err
+	}
+	composeFile := currentComposeFile()
+
+	cyan.Println("現有配置：")
+	if domain := existingEnv["DOMAIN"]; domain != "" {
+		fmt.Printf("  域名 Domain: %s\n", domain)
+	}
+	if port := existingEnv["HTTP_PORT"]; port != "" {
+		fmt.Printf("  端口 Port: %s\n", port)
+	}
+	if adminUser := existingEnv["ADMIN_LOGIN_USER"]; adminUser != "" {
+		fmt.Printf("  管理員帳號: %s\n", adminUser)
+	}
+	if v := detectNeticrmVersion(); v != "" {
+		fmt.Printf("  netiCRM 版本: %s\n", v)
+	}
+	fmt.Printf("  Compose 檔案: %s\n", composeFile)
+	fmt.Println()
+
+	if err := checkDocker(); err != nil {
+		return err
+	}
+	forgetAdminPasswordAfterLogin()
+	return dockerCompose(composeFile, "ps")
+}
+
+func runLogs(args []string) error {
+	fs := newFlagSet("logs")
+	follow := fs.Bool("follow", false, "持續輸出新的日誌")
+	tail := fs.String("tail", "100", "每個容器顯示的最後行數（all 為全部）")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := requireInstalled(); err != nil {
+		return err
+	}
+	if err := checkDocker(); err != nil {
+		return err
+	}
+
+	composeArgs := []string{"logs", "--tail", *tail}
+	if *follow {
+		composeArgs = append(composeArgs, "--follow")
+	}
+	composeArgs = append(composeArgs, fs.Args()...)
+
+	return dockerCompose(currentComposeFile(), composeArgs...)
+}
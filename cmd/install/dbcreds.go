@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/AlecAivazis/survey/v2"
+	"github.com/netivism/neticrm-selfhost/internal/envfile"
+)
+
+var (
+	drupalSettingsFile  = sitesDefaultDir + "/settings.php"
+	civicrmSettingsFile = sitesDefaultDir + "/civicrm.settings.php"
+)
+
+// drupalPasswordPattern 比對 settings.php 資料庫陣列中的 'password' => '...'
+var drupalPasswordPattern = regexp.MustCompile(`('password'\s*=>\s*)'(?:[^'\\]|\\.)*'`)
+
+// dbCredentials 為一組 MariaDB 帳號密碼
+type dbCredentials struct {
+	rootPassword string
+	user         string
+	password     string
+	database     string
+}
+
+func runRotateDBCredentials(args []string) error {
+	fs := newFlagSet("rotate-db-credentials")
+	currentRoot := fs.String("current-root-password", "", "資料庫目前的 root 密碼（預設讀取 .env，.env 已被手動修改時使用）")
+	yes := fs.Bool("yes", false, "不詢問確認，直接更換密碼")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := noArgs(fs); err != nil {
+		return err
+	}
+	if err := requireInstalled(); err != nil {
+		return err
+	}
+	if err := checkDocker(); err != nil {
+		return err
+	}
+	if !containerRunning(mariadbContainer) {
+		return fmt.Errorf("容器 %s 未執行，請先執行 %s start", mariadbContainer, programName())
+	}
+
+	env, err := envfile.ReadFile(targetFile)
+	if err != nil {
+		return fmt.Errorf("讀取 %s 失敗: %w", targetFile, err)
+	}
+	old := dbCredentials{
+		rootPassword: env["MYSQL_ROOT_PASSWORD"],
+		user:         envOrDefault(env, "MYSQL_USER", "neticrmdb"),
+		password:     env["MYSQL_PASSWORD"],
+		database:     envOrDefault(env, "MYSQL_DATABASE", "neticrmdb"),
+	}
+	if *currentRoot != "" {
+		old.rootPassword = *currentRoot
+	}
+
+	if _, err := mariadbQuery("root", old.rootPassword, "", "SELECT 1"); err != nil {
+		return fmt.Errorf("無法以目前的 root 密碼登入資料庫（%v）；若 .env 已被手動修改，請以 --current-root-password 指定資料庫實際使用的密碼", err)
+	}
+
+	// 先確認網站設定檔可以更新，再變更資料庫
+	originals := make(map[string][]byte)
+	for _, path := range []string{targetFile, drupalSettingsFile, civicrmSettingsFile} {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("讀取 %s 失敗，網站可能尚未完成安裝: %w", path, err)
+		}
+		originals[path] = data
+	}
+
+	next := old
+	next.rootPassword = randomPass(13)
+	next.password = randomPass(13)
+
+	drupalSettings, err := replaceDrupalPassword(string(originals[drupalSettingsFile]), next.password)
+	if err != nil {
+		return fmt.Errorf("%s: %w", drupalSettingsFile, err)
+	}
+	civicrmSettings, err := replaceDSNPassword(string(originals[civicrmSettingsFile]), next.user, next.password)
+	if err != nil {
+		return fmt.Errorf("%s: %w", civicrmSettingsFile, err)
+	}
+
+	if !*yes {
+		confirm := false
+		prompt := &survey.Confirm{
+			Message: fmt.Sprintf("將更換 MariaDB root 與 %s 的密碼並重新啟動 php-fpm，確定嗎？", old.user),
+			Default: false,
+		}
+		if err := survey.AskOne(prompt, &confirm); err != nil {
+			return err
+		}
+		if !confirm {
+			fmt.Println("未更換密碼。")
+			return nil
+		}
+	}
+
+	cyan.Println("更換資料庫密碼 ...")
+	if err := alterDBPasswords(old.rootPassword, next); err != nil {
+		return fmt.Errorf("更換資料庫密碼失敗，網站未變更: %w", err)
+	}
+
+	// 新密碼可以登入後才寫入設定檔，否則還原資料庫密碼
+	if err := verifyDBLogin(next); err != nil {
+		red.Printf("✗ 新密碼無法登入: %v\n", err)
+		return rollbackDBPasswords(next.rootPassword, old, errors.New("新密碼驗證失敗，已還原資料庫密碼"))
+	}
+	green.Println("✓ 新密碼驗證成功")
+
+	beginBackupSet(reasonRotateDB)
+	set, err := currentBackupSet()
+	if err == nil {
+		for _, path := range []string{targetFile, drupalSettingsFile, civicrmSettingsFile} {
+			if _, err = set.copyIn(path); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		return rollbackDBPasswords(next.rootPassword, old, fmt.Errorf("備份設定檔失敗，已還原資料庫密碼: %w", err))
+	}
+
+	err = writeFileKeepMode(drupalSettingsFile, []byte(drupalSettings))
+	if err == nil {
+		err = writeFileKeepMode(civicrmSettingsFile, []byte(civicrmSettings))
+	}
+	if err == nil {
+		err = updateEnvFile(map[string]string{
+			"MYSQL_ROOT_PASSWORD": next.rootPassword,
+			"MYSQL_PASSWORD":      next.password,
+		})
+	}
+	if err != nil {
+		for path, data := range originals {
+			if werr := writeFileKeepMode(path, data); werr != nil {
+				red.Printf("✗ 無法還原 %s: %v，原檔備份於 %s\n", path, werr, set.dir)
+			}
+		}
+		return rollbackDBPasswords(next.rootPassword, old, fmt.Errorf("更新設定檔失敗，已還原資料庫密碼與設定檔: %w", err))
+	}
+	green.Printf("✓ 已更新 %s、%s 與 %s，原檔備份於 %s\n", targetFile, drupalSettingsFile, civicrmSettingsFile, set.dir)
+
+	// php-fpm 的環境變數來自 .env，重新建立容器才會使用新密碼
+	cyan.Println("重新啟動 php-fpm ...")
+	if err := dockerCompose(currentComposeFile(), "up", "-d", "--no-deps", "php-fpm"); err != nil {
+		return err
+	}
+	if err := waitForDrupal(2 * time.Minute); err != nil {
+		return fmt.Errorf("%w；原設定檔備份於 %s", err, set.dir)
+	}
+
+	green.Println("✅ 資料庫密碼已更換")
+	return nil
+}
+
+// mariadbQuery 在 neticrm-mariadb 容器內以 TCP 連線執行 SQL，密碼經由環境變數傳遞而不出現在命令列
+func mariadbQuery(user, password, database, sql string) (string, error) {
+	args := []string{"exec", "-i", "-e", "MYSQL_PWD", mariadbContainer,
+		"mariadb", "--user=" + user, "--host=127.0.0.1", "--batch", "--skip-column-names"}
+	if database != "" {
+		args = append(args, "--database="+database)
+	}
+	cmd := exec.Command("docker", args...)
+	cmd.Env = append(os.Environ(), "MYSQL_PWD="+password)
+	cmd.Stdin = strings.NewReader(sql)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// alterDBPasswords 以 root 將 root 與網站帳號在所有主機上的密碼改為 next
+func alterDBPasswords(rootPassword string, next dbCredentials) error {
+	var sql strings.Builder
+	accounts := []struct{ user, password string }{
+		{"root", next.rootPassword},
+		{next.user, next.password},
+	}
+	for _, a := range accounts {
+		out, err := mariadbQuery("root", rootPassword, "", "SELECT Host FROM mysql.user WHERE User = "+sqlQuote(a.user)+";")
+		if err != nil {
+			return err
+		}
+		hosts := strings.Fields(out)
+		if len(hosts) == 0 {
+			return fmt.Errorf("資料庫中沒有帳號 %s", a.user)
+		}
+		for _, host := range hosts {
+			fmt.Fprintf(&sql, "ALTER USER %s@%s IDENTIFIED BY %s;\n", sqlQuote(a.user), sqlQuote(host), sqlQuote(a.password))
+		}
+	}
+	sql.WriteString("FLUSH PRIVILEGES;\n")
+
+	_, err := mariadbQuery("root", rootPassword, "", sql.String())
+	return err
+}
+
+// verifyDBLogin 確認 root 與網站帳號都能以新密碼登入
+func verifyDBLogin(c dbCredentials) error {
+	if _, err := mariadbQuery("root", c.rootPassword, "", "SELECT 1"); err != nil {
+		return fmt.Errorf("root: %w", err)
+	}
+	if _, err := mariadbQuery(c.user, c.password, c.database, "SELECT 1"); err != nil {
+		return fmt.Errorf("%s: %w", c.user, err)
+	}
+	return nil
+}
+
+// rollbackDBPasswords 將資料庫密碼改回 old，回傳 cause；還原失敗時說明目前的 root 密碼
+func rollbackDBPasswords(rootPassword string, old dbCredentials, cause error) error {
+	if err := alterDBPasswords(rootPassword, old); err != nil {
+		red.Printf("✗ 無法還原資料庫密碼: %v\n", err)
+		fmt.Printf("資料庫目前的 root 密碼為 %s，請手動還原。\n", rootPassword)
+	}
+	return cause
+}
+
+// replaceDrupalPassword 更新 settings.php 中 $databases['default']['default'] 的密碼
+func replaceDrupalPassword(content, password string) (string, error) {
+	start := strings.Index(content, "$databases['default']['default']")
+	if start < 0 {
+		return "", errors.New("找不到 $databases['default']['default']")
+	}
+	loc := drupalPasswordPattern.FindStringSubmatchIndex(content[start:])
+	if loc == nil {
+		return "", errors.New("找不到資料庫密碼設定")
+	}
+	prefix := content[start+loc[2] : start+loc[3]]
+	return content[:start+loc[0]] + prefix + phpQuote(password) + content[start+loc[1]:], nil
+}
+
+// replaceDSNPassword 更新 civicrm.settings.php 中所有 mysql://user:password@ 的密碼
+func replaceDSNPassword(content, user, password string) (string, error) {
+	pattern := regexp.MustCompile(`(mysqli?://` + regexp.QuoteMeta(rawurlencode(user)) + `:)[^'"\s]*@`)
+	if !pattern.MatchString(content) {
+		return "", fmt.Errorf("找不到帳號 %s 的資料庫 DSN", user)
+	}
+	// 編碼後的密碼只含英數字與 -_.~%，不會被當成 $1 之類的參照
+	return pattern.ReplaceAllString(content, "${1}"+rawurlencode(password)+"@"), nil
+}
+
+// phpQuote 回傳 PHP 單引號字串
+func phpQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return "'" + strings.ReplaceAll(s, `'`, `\'`) + "'"
+}
+
+// sqlQuote 回傳 SQL 單引號字串
+func sqlQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return "'" + strings.ReplaceAll(s, `'`, `''`) + "'"
+}
+
+// rawurlencode 與 PHP 的 rawurlencode 相同，CiviCRM 解析 DSN 時會以 rawurldecode 還原
+func rawurlencode(s string) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', strings.IndexByte("-_.~", c) >= 0:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// writeFileKeepMode 覆寫檔案並保留原本的權限，Drupal 設為唯讀的設定檔會暫時加上寫入權限；
+// 檔案不存在時以 0644 建立
+func writeFileKeepMode(path string, data []byte) error {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return os.WriteFile(path, data, 0644)
+	}
+	if err != nil {
+		return err
+	}
+	mode := info.Mode().Perm()
+	if mode&0200 == 0 {
+		if err := os.Chmod(path, mode|0200); err != nil {
+			return err
+		}
+		defer os.Chmod(path, mode)
+	}
+	return os.WriteFile(path, data, mode)
+}
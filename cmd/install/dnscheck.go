@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/AlecAivazis/survey/v2"
+)
+
+// dnsResolverEnv 指定查詢網域時使用的 DNS 伺服器（host 或 host:port），未設定時使用系統的設定
+const dnsResolverEnv = "NETICRM_DNS_RESOLVER"
+
+// dnsMismatchError 表示網域的 DNS 記錄沒有全部指向此主機
+type dnsMismatchError struct {
+	domain string
+	// records 為網域的 A/AAAA 記錄，沒有記錄時為空
+	records []string
+	// foreign 為不屬於此主機的記錄
+	foreign []string
+	// local 為此主機網路介面的位址
+	local []string
+}
+
+func (e *dnsMismatchError) Error() string {
+	if len(e.records) == 0 {
+		return e.domain + " 沒有 A 或 AAAA 記錄"
+	}
+	return e.domain + " 的 " + strings.Join(e.foreign, "、") + " 不是此主機的位址"
+}
+
+// english 回傳英文的錯誤訊息，供選擇英文的安裝精靈使用
+func (e *dnsMismatchError) english() string {
+	if len(e.records) == 0 {
+		return e.domain + " has no A or AAAA record"
+	}
+	return e.domain + " points to " + strings.Join(e.foreign, ", ") + ", which is not an address of this host"
+}
+
+// natHint 在此主機沒有公開位址時回傳 true，DNS 指向的對外 IP 可能由 NAT 轉送到此主機
+func (e *dnsMismatchError) natHint() bool {
+	for _, s := range e.local {
+		if ip := net.ParseIP(s); ip != nil && !ip.IsPrivate() {
+			return false
+		}
+	}
+	return len(e.records) > 0
+}
+
+// dnsResolver 回傳查詢網域使用的 resolver，可由 NETICRM_DNS_RESOLVER 指定 DNS 伺服器
+func dnsResolver() *net.Resolver {
+	server := strings.TrimSpace(os.Getenv(dnsResolverEnv))
+	if server == "" {
+		return net.DefaultResolver
+	}
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(normalizeBind(server), "53")
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, server)
+		},
+	}
+}
+
+// checkDomainDNS 確認網域的 A/AAAA 記錄都指向此主機網路介面的位址，Let's Encrypt 才能完成驗證
+func checkDomainDNS(domain string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ips, err := dnsResolver().LookupIP(ctx, "ip", domain)
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		err = nil
+	}
+	if err != nil {
+		return fmt.Errorf("無法查詢 %s 的 DNS 記錄: %w", domain, err)
+	}
+
+	local := map[string]bool{}
+	mismatch := &dnsMismatchError{domain: domain}
+	for _, a := range localAddresses() {
+		if !local[a.ip] {
+			local[a.ip] = true
+			mismatch.local = append(mismatch.local, a.ip)
+		}
+	}
+	for _, ip := range ips {
+		mismatch.records = append(mismatch.records, ip.String())
+		if !local[ip.String()] {
+			mismatch.foreign = append(mismatch.foreign, ip.String())
+		}
+	}
+	if len(mismatch.records) > 0 && len(mismatch.foreign) == 0 {
+		return nil
+	}
+	return mismatch
+}
+
+// confirmDomainDNS 在申請憑證前檢查網域的 DNS，沒有指向此主機時警告並詢問是否繼續；yes 為 true 時只警告
+func confirmDomainDNS(domain, lang string, yes bool) (bool, error) {
+	zh := lang == "zh-hant"
+	if zh {
+		cyan.Printf("檢查 %s 的 DNS 記錄 ...\n", domain)
+	} else {
+		cyan.Printf("Checking the DNS records of %s ...\n", domain)
+	}
+	err := checkDomainDNS(domain)
+	if err == nil {
+		if zh {
+			green.Printf("✓ %s 已指向此主機\n", domain)
+		} else {
+			green.Printf("✓ %s points to this host\n", domain)
+		}
+		return true, nil
+	}
+
+	var mismatch *dnsMismatchError
+	switch {
+	case !errors.As(err, &mismatch):
+		yellow.Printf("⚠ %v\n", err)
+	case zh:
+		yellow.Printf("⚠ %v\n", mismatch)
+		if len(mismatch.local) > 0 {
+			fmt.Printf("此主機的位址：%s\n", strings.Join(mismatch.local, "、"))
+		}
+		if mismatch.natHint() {
+			fmt.Println("此主機沒有公開 IP，若網域指向的是轉送到此主機的對外 IP（NAT），可以繼續。")
+		}
+	default:
+		yellow.Printf("⚠ %s\n", mismatch.english())
+		if len(mismatch.local) > 0 {
+			fmt.Printf("Addresses of this host: %s\n", strings.Join(mismatch.local, ", "))
+		}
+		if mismatch.natHint() {
+			fmt.Println("This host has no public IP; continue if the domain points to a public IP forwarded here (NAT).")
+		}
+	}
+	if zh {
+		fmt.Println("DNS 未指向此主機時 Caddy 無法取得憑證，反覆失敗可能達到 Let's Encrypt 的申請次數限制。")
+	} else {
+		fmt.Println("Caddy cannot obtain a certificate until DNS points here, and repeated failures can hit Let's Encrypt rate limits.")
+	}
+	if yes {
+		return true, nil
+	}
+
+	message := "Continue anyway?"
+	if zh {
+		message = "仍要繼續嗎？"
+	}
+	confirm := false
+	if err := survey.AskOne(&survey.Confirm{Message: message, Default: false}, &confirm); err != nil {
+		return false, err
+	}
+	return confirm, nil
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// serveDNS 在本機啟動只回答 A/AAAA 查詢的 UDP DNS 伺服器，並以 NETICRM_DNS_RESOLVER 指向它；
+// records 沒有的網域回答 NXDOMAIN
+func serveDNS(t *testing.T, records map[string][]string) {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if resp := dnsAnswer(buf[:n], records); resp != nil {
+				conn.WriteTo(resp, addr)
+			}
+		}
+	}()
+	t.Setenv(dnsResolverEnv, conn.LocalAddr().String())
+}
+
+// dnsAnswer 依 RFC 1035 產生查詢的回應，查詢格式不正確時回傳 nil
+func dnsAnswer(query []byte, records map[string][]string) []byte {
+	if len(query) < 12 {
+		return nil
+	}
+	// 問題區段為以長度開頭的標籤，以 0 結尾，接著是 QTYPE 與 QCLASS
+	var labels []string
+	i := 12
+	for i < len(query) && query[i] != 0 {
+		n := int(query[i])
+		if i+1+n > len(query) {
+			return nil
+		}
+		labels = append(labels, string(query[i+1:i+1+n]))
+		i += 1 + n
+	}
+	if i+5 > len(query) {
+		return nil
+	}
+	question := query[12 : i+5]
+	qtype := binary.BigEndian.Uint16(query[i+1:])
+	name := strings.ToLower(strings.Join(labels, "."))
+
+	var answers []byte
+	count := 0
+	for _, s := range records[name] {
+		ip := net.ParseIP(s)
+		rdata, rtype := ip.To4(), uint16(1) // A
+		if rdata == nil {
+			rdata, rtype = ip.To16(), 28 // AAAA
+		}
+		if rtype != qtype {
+			continue
+		}
+		answers = append(answers, 0xc0, 12) // 指向問題區段中的名稱
+		answers = binary.BigEndian.AppendUint16(answers, rtype)
+		answers = binary.BigEndian.AppendUint16(answers, 1) // IN
+		answers = binary.BigEndian.AppendUint32(answers, 60)
+		answers = binary.BigEndian.AppendUint16(answers, uint16(len(rdata)))
+		answers = append(answers, rdata...)
+		count++
+	}
+
+	flags := uint16(0x8180) // 回應、要求遞迴、可遞迴
+	if _, ok := records[name]; !ok {
+		flags |= 3 // NXDOMAIN
+	}
+	resp := append([]byte{}, query[:2]...)
+	resp = binary.BigEndian.AppendUint16(resp, flags)
+	resp = binary.BigEndian.AppendUint16(resp, 1)
+	resp = binary.BigEndian.AppendUint16(resp, uint16(count))
+	resp = append(resp, 0, 0, 0, 0)
+	resp = append(resp, question...)
+	return append(resp, answers...)
+}
+
+func TestCheckDomainDNS(t *testing.T) {
+	addrs := localAddresses()
+	if len(addrs) == 0 {
+		t.Skip("no network interface address")
+	}
+	local := addrs[0].ip
+
+	serveDNS(t, map[string][]string{
+		"crm.example.org":     {local},
+		"foreign.example.org": {"198.51.100.7"},
+		"mixed.example.org":   {local, "2001:db8::1"},
+		"empty.example.org":   {},
+	})
+
+	tests := []struct {
+		domain  string
+		ok      bool
+		records []string
+		foreign []string
+	}{
+		{domain: "crm.example.org", ok: true},
+		{domain: "foreign.example.org", records: []string{"198.51.100.7"}, foreign: []string{"198.51.100.7"}},
+		{domain: "mixed.example.org", records: []string{local, "2001:db8::1"}, foreign: []string{"2001:db8::1"}},
+		{domain: "empty.example.org"},
+		{domain: "missing.example.org"},
+	}
+	for _, tt := range tests {
+		err := checkDomainDNS(tt.domain)
+		if tt.ok {
+			if err != nil {
+				t.Errorf("checkDomainDNS(%q) = %v, want nil", tt.domain, err)
+			}
+			continue
+		}
+		var mismatch *dnsMismatchError
+		if !errors.As(err, &mismatch) {
+			t.Errorf("checkDomainDNS(%q) = %v, want a dnsMismatchError", tt.domain, err)
+			continue
+		}
+		// 記錄的順序取決於 A 與 AAAA 查詢的回應順序
+		if !sameStrings(mismatch.records, tt.records) {
+			t.Errorf("checkDomainDNS(%q) records = %v, want %v", tt.domain, mismatch.records, tt.records)
+		}
+		if !reflect.DeepEqual(mismatch.foreign, tt.foreign) {
+			t.Errorf("checkDomainDNS(%q) foreign = %v, want %v", tt.domain, mismatch.foreign, tt.foreign)
+		}
+	}
+}
+
+// sameStrings 比較兩個字串切片是否含有相同的元素，不計順序
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	count := map[string]int{}
+	for _, s := range a {
+		count[s]++
+	}
+	for _, s := range b {
+		if count[s]--; count[s] < 0 {
+			return false
+		}
+	}
+	return true
+}
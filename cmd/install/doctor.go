@@ -0,0 +1,348 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/netivism/neticrm-selfhost/internal/envfile"
+)
+
+// checkStatus 為單項檢查的結果
+type checkStatus int
+
+const (
+	checkPass checkStatus = iota
+	checkWarn
+	checkFail
+)
+
+// checkResult 為 doctor 表格中的一列，hint 為未通過時的修正方式
+type checkResult struct {
+	status checkStatus
+	name   string
+	detail string
+	hint   string
+}
+
+// doctor 收集檢查結果，訊息依 lang 顯示英文或中文
+type doctor struct {
+	lang    string
+	results []checkResult
+	// env 為 .env 的內容，尚未安裝時為 nil
+	env    map[string]string
+	useSSL bool
+	// arch 為 Docker daemon 的 CPU 架構，無法連線時為空
+	arch string
+}
+
+// tr 依語言選擇訊息
+func (d *doctor) tr(zh, en string) string {
+	if d.lang == "en" {
+		return en
+	}
+	return zh
+}
+
+func (d *doctor) pass(name, detail string) {
+	d.results = append(d.results, checkResult{status: checkPass, name: name, detail: detail})
+}
+
+func (d *doctor) warn(name, detail, hint string) {
+	d.results = append(d.results, checkResult{status: checkWarn, name: name, detail: detail, hint: hint})
+}
+
+func (d *doctor) fail(name, detail, hint string) {
+	d.results = append(d.results, checkResult{status: checkFail, name: name, detail: detail, hint: hint})
+}
+
+func runDoctor(args []string) error {
+	fs := newFlagSet("doctor")
+	language := fs.String("language", "", "訊息語言：en 或 zh-hant（預設為 NETICRM_LANGUAGE 或 .env 的 LANGUAGE）")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := noArgs(fs); err != nil {
+		return err
+	}
+
+	d := &doctor{lang: *language}
+	if fileExists(targetFile) {
+		env, err := envfile.Load(targetFile)
+		if err != nil {
+			return fmt.Errorf("讀取 %s 失敗: %w", targetFile, err)
+		}
+		d.env = env.Map()
+		d.useSSL = envUsesSSL(env)
+	}
+	if d.lang == "" {
+		d.lang = os.Getenv("NETICRM_LANGUAGE")
+	}
+	if d.lang == "" {
+		d.lang = d.env["LANGUAGE"]
+	}
+	if d.lang != "" && d.lang != "en" && d.lang != "zh-hant" {
+		return newUsageError("不支援的語言 %q，請使用 en 或 zh-hant", d.lang)
+	}
+
+	d.checkDocker()
+	d.checkProjectFiles()
+	d.checkImages()
+	checkHost(d)
+	d.checkPorts()
+	d.print()
+
+	for _, r := range d.results {
+		if r.status == checkFail {
+			return errors.New(d.tr("主機環境檢查未通過", "host readiness checks failed"))
+		}
+	}
+	return nil
+}
+
+// checkDocker 檢查 Docker CLI、Compose 插件，以及目前的使用者能否連線到 daemon
+func (d *doctor) checkDocker() {
+	name := "Docker"
+	if _, err := exec.LookPath("docker"); err != nil {
+		d.fail(name, d.tr("找不到 docker 指令", "docker command not found"),
+			d.tr("請依 https://docs.docker.com/engine/install/ 安裝 Docker Engine",
+				"Install Docker Engine following https://docs.docker.com/engine/install/"))
+		return
+	}
+
+	out, err := exec.Command("docker", "info", "--format", "{{.ServerVersion}} {{.Architecture}}").CombinedOutput()
+	msg := strings.TrimSpace(string(out))
+	switch {
+	case err == nil:
+		version, arch, _ := strings.Cut(msg, " ")
+		d.arch = normalizeArch(arch)
+		d.pass(name, fmt.Sprintf(d.tr("%s（%s）", "%s (%s)"), version, d.arch))
+	case strings.Contains(msg, "permission denied"):
+		d.fail(name, d.tr("目前的使用者沒有權限連線到 Docker daemon", "the current user cannot access the Docker daemon"),
+			d.tr("請執行 sudo usermod -aG docker $USER 後重新登入，或以 sudo 執行安裝程式",
+				"Run sudo usermod -aG docker $USER and log in again, or run the installer with sudo"))
+	default:
+		d.fail(name, d.tr("無法連線到 Docker daemon", "cannot connect to the Docker daemon"),
+			d.tr("請執行 sudo systemctl enable --now docker 啟動 Docker",
+				"Start Docker with sudo systemctl enable --now docker"))
+	}
+
+	if out, err := exec.Command("docker", "compose", "version", "--short").Output(); err != nil {
+		d.fail("Docker Compose", d.tr("Compose 插件未安裝或未啟用", "Compose plugin is missing"),
+			d.tr("請安裝 docker-compose-plugin 套件（例如 sudo apt install docker-compose-plugin）",
+				"Install the docker-compose-plugin package (e.g. sudo apt install docker-compose-plugin)"))
+	} else {
+		d.pass("Docker Compose", strings.TrimSpace(string(out)))
+	}
+}
+
+// checkProjectFiles 確認在 neticrm-selfhost 目錄下執行
+func (d *doctor) checkProjectFiles() {
+	var missing []string
+	for _, f := range []string{exampleFile, defaultComposeFile, sslComposeFile, exampleCaddyfile} {
+		if !fileExists(f) {
+			missing = append(missing, f)
+		}
+	}
+	name := d.tr("專案檔案", "Project files")
+	if len(missing) > 0 {
+		d.fail(name, d.tr("找不到 ", "missing ")+strings.Join(missing, ", "),
+			d.tr("請在 neticrm-selfhost 目錄下執行，或重新 git clone https://github.com/netivism/neticrm-selfhost",
+				"Run the installer inside the neticrm-selfhost directory, or git clone https://github.com/netivism/neticrm-selfhost again"))
+		return
+	}
+	d.pass(name, d.tr("完整", "present"))
+}
+
+// checkImages 確認 compose 檔案中的映像檔提供 Docker daemon 的 CPU 架構
+func (d *doctor) checkImages() {
+	if d.arch == "" {
+		return
+	}
+	files := []string{defaultComposeFile, sslComposeFile}
+	if d.env != nil {
+		files = []string{composeFileFor(d.useSSL)}
+	}
+	var images []string
+	for _, file := range files {
+		for _, image := range composeImages(file) {
+			if !slices.Contains(images, image) {
+				images = append(images, image)
+			}
+		}
+	}
+	sort.Strings(images)
+
+	name := d.tr("映像檔架構", "Image architecture")
+	var unsupported, unknown []string
+	for _, image := range images {
+		archs, err := imageArchitectures(image)
+		switch {
+		case err != nil:
+			unknown = append(unknown, image)
+		case !slices.Contains(archs, d.arch):
+			unsupported = append(unsupported, fmt.Sprintf(d.tr("%s（%s）", "%s (%s)"), image, strings.Join(archs, ", ")))
+		}
+	}
+	switch {
+	case len(unsupported) > 0:
+		d.fail(name, d.tr("不支援 ", "no ")+d.arch+d.tr("：", " build: ")+strings.Join(unsupported, ", "),
+			d.tr("請改用上列架構的主機（通常為 amd64 或 arm64）",
+				"Use a host with one of the listed architectures (usually amd64 or arm64)"))
+	case len(unknown) > 0:
+		d.warn(name, d.tr("無法查詢 ", "could not inspect ")+strings.Join(unknown, ", "),
+			d.tr("請確認主機可以連線到 ghcr.io 與 Docker Hub，或先執行 docker compose pull",
+				"Check that the host can reach ghcr.io and Docker Hub, or run docker compose pull first"))
+	default:
+		d.pass(name, fmt.Sprintf(d.tr("%d 個映像檔皆支援 %s", "all %d images support %s"), len(images), d.arch))
+	}
+}
+
+// imageArchitectures 回傳映像檔提供的 Linux CPU 架構；已下載的映像檔直接讀取本機資訊
+func imageArchitectures(image string) ([]string, error) {
+	if out, err := exec.Command("docker", "image", "inspect", "--format", "{{.Architecture}}", image).Output(); err == nil {
+		return []string{normalizeArch(strings.TrimSpace(string(out)))}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "docker", "manifest", "inspect", image).Output()
+	if err != nil {
+		return nil, err
+	}
+	var index struct {
+		Manifests []struct {
+			Platform struct {
+				OS           string `json:"os"`
+				Architecture string `json:"architecture"`
+			} `json:"platform"`
+		} `json:"manifests"`
+	}
+	if err := json.Unmarshal(out, &index); err != nil {
+		return nil, err
+	}
+	var archs []string
+	for _, m := range index.Manifests {
+		if m.Platform.OS == "linux" && !slices.Contains(archs, m.Platform.Architecture) {
+			archs = append(archs, m.Platform.Architecture)
+		}
+	}
+	if len(archs) == 0 {
+		// 單一架構的映像檔沒有 manifest list，需下載後才能得知
+		return nil, errors.New("no manifest list")
+	}
+	return archs, nil
+}
+
+// normalizeArch 將 uname 的架構名稱轉為映像檔使用的名稱
+func normalizeArch(arch string) string {
+	switch arch {
+	case "x86_64":
+		return "amd64"
+	case "aarch64":
+		return "arm64"
+	case "armv7l":
+		return "arm"
+	case "":
+		return runtime.GOARCH
+	}
+	return arch
+}
+
+// checkPorts 確認網站需要的埠沒有被其他程式佔用
+func (d *doctor) checkPorts() {
+	bind := normalizeBind(d.env["HTTP_BIND"])
+	httpPort := envOrDefault(d.env, "HTTP_PORT", "8080")
+	var ports []string
+	switch {
+	case d.env == nil:
+		// 尚未安裝，SSL 模式需要 80 與 443，否則需要 HTTP_PORT
+		ports = []string{"80", "443", httpPort}
+	case d.useSSL:
+		bind, ports = "", []string{"80", "443"}
+	default:
+		ports = []string{httpPort}
+	}
+
+	for _, port := range ports {
+		name := d.tr("連接埠 ", "Port ") + port
+		err := checkPort(bind, port)
+		var busy *portInUseError
+		switch {
+		case err == nil:
+			d.pass(name, d.tr("可使用", "free"))
+		case !errors.As(err, &busy):
+			d.fail(name, err.Error(), d.tr("請修正 .env 的 HTTP_BIND 或 HTTP_PORT", "Fix HTTP_BIND or HTTP_PORT in .env"))
+		case d.env == nil && port != httpPort:
+			d.warn(name, d.tr(busy.Error(), busy.english()),
+				d.tr("啟用 SSL 需要 80 與 443 埠，"+busy.hint()+"，或安裝時不啟用 SSL",
+					"SSL needs ports 80 and 443; stop the service using it or install without SSL"))
+		case d.useSSL:
+			d.fail(name, d.tr(busy.Error(), busy.english()),
+				d.tr(busy.hint()+"，或以 "+programName()+" ssl disable 改由 HTTP_PORT 提供網站",
+					"Stop the service using it, or serve the site on HTTP_PORT with "+programName()+" ssl disable"))
+		default:
+			busy.next = nextFreePort(bind, port)
+			next := busy.next
+			if next == "" {
+				next = d.tr("其他埠", "another port")
+			}
+			d.fail(name, d.tr(busy.Error(), busy.english()),
+				d.tr(busy.hint()+"，或以 "+programName()+" reconfigure --port "+next+" 改用其他埠",
+					"Stop the service using it, or switch with "+programName()+" reconfigure --port "+next))
+		}
+	}
+}
+
+// print 以表格顯示檢查結果，未通過的項目下方顯示修正方式
+func (d *doctor) print() {
+	labels := map[checkStatus]string{
+		checkPass: d.tr("✓ 通過", "✓ PASS"),
+		checkWarn: d.tr("! 警告", "! WARN"),
+		checkFail: d.tr("✗ 失敗", "✗ FAIL"),
+	}
+	width := 0
+	for _, r := range d.results {
+		width = max(width, displayWidth(r.name))
+	}
+
+	fmt.Println()
+	for _, r := range d.results {
+		label := labels[r.status]
+		switch r.status {
+		case checkPass:
+			label = green.Sprint(label)
+		case checkWarn:
+			label = yellow.Sprint(label)
+		case checkFail:
+			label = red.Sprint(label)
+		}
+		fmt.Printf("%s  %s%s  %s\n", label, r.name, strings.Repeat(" ", width-displayWidth(r.name)), r.detail)
+		if r.hint != "" {
+			fmt.Printf("        → %s\n", r.hint)
+		}
+	}
+	fmt.Println()
+}
+
+// displayWidth 回傳字串在終端機的顯示寬度，中日韓文字與全形符號佔兩格
+func displayWidth(s string) int {
+	w := 0
+	for _, r := range s {
+		if unicode.Is(unicode.Han, r) || (r >= 0x3000 && r <= 0x303f) || (r >= 0xff00 && r <= 0xffef) {
+			w += 2
+		} else {
+			w++
+		}
+	}
+	return w
+}
@@ -0,0 +1,182 @@
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const gib = 1 << 30
+
+// checkHost 檢查磁碟、記憶體與 SELinux/AppArmor 等 Linux 主機設定
+func checkHost(d *doctor) {
+	d.checkDisk()
+	d.checkMemory()
+	d.checkSELinux()
+	d.checkAppArmor()
+}
+
+// checkDisk 檢查 data/ 與 Docker 資料目錄所在的磁碟剩餘空間
+func (d *doctor) checkDisk() {
+	dirs := []string{"data/"}
+	if out, err := exec.Command("docker", "info", "--format", "{{.DockerRootDir}}").Output(); err == nil {
+		dirs = append(dirs, strings.TrimSpace(string(out)))
+	}
+
+	seen := map[syscall.Fsid]bool{}
+	for _, dir := range dirs {
+		var st syscall.Statfs_t
+		if err := syscall.Statfs(dir, &st); err != nil || seen[st.Fsid] {
+			continue
+		}
+		seen[st.Fsid] = true
+
+		name := d.tr("磁碟空間 ", "Disk space ") + dir
+		free := st.Bavail * uint64(st.Bsize)
+		detail := fmt.Sprintf(d.tr("剩餘 %s", "%s free"), formatGiB(free))
+		hint := d.tr("請清理磁碟（例如 docker system prune 或刪除 backups/ 中的舊備份），或將 "+dir+" 移到較大的磁碟",
+			"Free up space (e.g. docker system prune or remove old sets under backups/), or move "+dir+" to a larger disk")
+		switch {
+		case free < 2*gib:
+			d.fail(name, detail+d.tr("，至少需要 2 GiB", "; at least 2 GiB is required"), hint)
+		case free < 5*gib:
+			d.warn(name, detail+d.tr("，建議 5 GiB 以上", "; 5 GiB or more is recommended"), hint)
+		default:
+			d.pass(name, detail)
+		}
+	}
+}
+
+// checkMemory 依 /proc/meminfo 檢查記憶體與 swap
+func (d *doctor) checkMemory() {
+	info, err := readMeminfo()
+	if err != nil {
+		return
+	}
+	mem, swap := info["MemTotal"], info["SwapTotal"]
+
+	name := d.tr("記憶體", "Memory")
+	detail := formatGiB(mem)
+	switch {
+	case mem < gib:
+		d.fail(name, detail+d.tr("，至少需要 1 GiB", "; at least 1 GiB is required"),
+			d.tr("請升級主機記憶體；MariaDB、PHP 與首次安裝的 drush 需要 1 GiB 以上",
+				"Upgrade the host; MariaDB, PHP and the first drush install need more than 1 GiB"))
+	case mem < 2*gib:
+		d.warn(name, detail+d.tr("，建議 2 GiB 以上", "; 2 GiB or more is recommended"),
+			d.tr("記憶體不足時請確認有設定 swap", "Make sure swap is configured on small hosts"))
+	default:
+		d.pass(name, detail)
+	}
+
+	name = "Swap"
+	switch {
+	case swap > 0:
+		d.pass(name, formatGiB(swap))
+	case mem < 4*gib:
+		d.warn(name, d.tr("未設定", "none"),
+			d.tr("記憶體小於 4 GiB 時建議加入 swap：sudo fallocate -l 2G /swapfile && sudo chmod 600 /swapfile && sudo mkswap /swapfile && sudo swapon /swapfile",
+				"Add swap on hosts with less than 4 GiB: sudo fallocate -l 2G /swapfile && sudo chmod 600 /swapfile && sudo mkswap /swapfile && sudo swapon /swapfile"))
+	default:
+		d.pass(name, d.tr("未設定", "none"))
+	}
+}
+
+// readMeminfo 讀取 /proc/meminfo，數值單位為 byte
+func readMeminfo() (map[string]uint64, error) {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info := map[string]uint64{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(value)
+		if len(fields) == 0 {
+			continue
+		}
+		n, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		if len(fields) > 1 && fields[1] == "kB" {
+			n *= 1024
+		}
+		info[key] = n
+	}
+	return info, scanner.Err()
+}
+
+// checkSELinux 在 SELinux 為 enforcing 時確認 data/ 與 container/ 已標記為容器可存取
+func (d *doctor) checkSELinux() {
+	data, err := os.ReadFile("/sys/fs/selinux/enforce")
+	if err != nil {
+		return
+	}
+	name := "SELinux"
+	if strings.TrimSpace(string(data)) != "1" {
+		d.pass(name, "permissive")
+		return
+	}
+
+	var unlabeled []string
+	for _, dir := range []string{"data", "container"} {
+		label := make([]byte, 256)
+		n, err := syscall.Getxattr(dir, "security.selinux", label)
+		if err != nil {
+			continue
+		}
+		if l := string(label[:n]); !strings.Contains(l, "container_file_t") && !strings.Contains(l, "svirt_sandbox_file_t") {
+			unlabeled = append(unlabeled, dir)
+		}
+	}
+	if len(unlabeled) > 0 {
+		dirs := strings.Join(unlabeled, " ")
+		d.fail(name, d.tr("enforcing，"+dirs+" 未標記為 container_file_t，容器無法讀寫掛載的目錄", "enforcing and "+dirs+" is not labelled container_file_t; containers cannot use the mounts"),
+			d.tr("請執行 sudo chcon -R -t container_file_t "+dirs+"，或在 compose 檔案的 volumes 加上 :z",
+				"Run sudo chcon -R -t container_file_t "+dirs+", or add :z to the volumes in the compose file"))
+		return
+	}
+	d.pass(name, d.tr("enforcing，掛載目錄已標記", "enforcing, mounts are labelled"))
+}
+
+// checkAppArmor 檢查 AppArmor 與 snap 版 Docker 的限制
+func (d *doctor) checkAppArmor() {
+	data, err := os.ReadFile("/sys/module/apparmor/parameters/enabled")
+	if err != nil || strings.TrimSpace(string(data)) != "Y" {
+		return
+	}
+	name := "AppArmor"
+
+	// snap 版 Docker 受 AppArmor 限制，只能掛載家目錄下的檔案
+	if path, err := exec.LookPath("docker"); err == nil {
+		resolved, _ := filepath.EvalSymlinks(path)
+		wd, _ := os.Getwd()
+		home, _ := os.UserHomeDir()
+		if strings.HasPrefix(resolved, "/snap/") && (home == "" || !strings.HasPrefix(wd, home+"/")) {
+			d.fail(name, d.tr("snap 版 Docker 無法掛載家目錄以外的 "+wd, "snap Docker cannot mount "+wd+" outside your home directory"),
+				d.tr("請改以 https://docs.docker.com/engine/install/ 的套件庫安裝 Docker，或將專案移到家目錄下",
+					"Install Docker from the repositories at https://docs.docker.com/engine/install/, or move the project under your home directory"))
+			return
+		}
+	}
+	d.pass(name, d.tr("已啟用，容器使用 docker-default 設定檔", "enabled, containers use the docker-default profile"))
+}
+
+// formatGiB 以 GiB 顯示容量
+func formatGiB(n uint64) string {
+	return fmt.Sprintf("%.1f GiB", float64(n)/gib)
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+// checkHost 只在 Linux 主機檢查磁碟、記憶體與 SELinux/AppArmor；其他系統使用 Docker Desktop，由其虛擬機器管理資源
+func checkHost(d *doctor) {
+	d.warn(d.tr("主機資源", "Host resources"), d.tr("只在 Linux 檢查", "only checked on Linux"),
+		d.tr("請在 Docker Desktop 的 Settings > Resources 確認至少有 2 GiB 記憶體與 5 GiB 磁碟空間",
+			"Check Docker Desktop Settings > Resources for at least 2 GiB memory and 5 GiB disk"))
+}
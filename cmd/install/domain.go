@@ -0,0 +1,371 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/AlecAivazis/survey/v2"
+)
+
+// civicrmURLScript 將 civicrm_domain.config_backend 中主機為 OLD_HOST 的網址改為 NEW_URL，路徑不變。
+// config_backend 是 PHP 序列化的陣列，直接以 SQL 取代字串會破壞長度資訊，因此在 PHP 內反序列化後再寫回
+const civicrmURLScript = `
+civicrm_initialize();
+$pattern = '#https?://' . preg_quote(getenv('OLD_HOST'), '#') . '(:[0-9]+)?(?=/|$)#i';
+$new = getenv('NEW_URL');
+$dao = CRM_Core_DAO::executeQuery('SELECT id, config_backend FROM civicrm_domain');
+while ($dao->fetch()) {
+  $config = unserialize($dao->config_backend);
+  if (!is_array($config)) {
+    continue;
+  }
+  array_walk_recursive($config, function (&$value) use ($pattern, $new) {
+    if (is_string($value)) {
+      $value = preg_replace($pattern, $new, $value);
+    }
+  });
+  CRM_Core_DAO::executeQuery('UPDATE civicrm_domain SET config_backend = %1 WHERE id = %2', array(
+    1 => array(serialize($config), 'String'),
+    2 => array($dao->id, 'Integer'),
+  ));
+}
+`
+
+// siteSettingsFiles 為記錄網站網址的 Drupal 與 CiviCRM 設定檔
+var siteSettingsFiles = []string{drupalSettingsFile, civicrmSettingsFile}
+
+func runChangeDomain(args []string) error {
+	fs := newFlagSet("change-domain")
+	yes := fs.Bool("yes", false, "不詢問確認，直接變更網域")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return newUsageError("請指定新的網域，例如 %s change-domain crm.example.org", programName())
+	}
+	newDomain, err := normalizeDomain(fs.Arg(0))
+	if err != nil {
+		return usageError{msg: err.Error()}
+	}
+	if err := requireInstalled(); err != nil {
+		return err
+	}
+
+	cfg, err := loadCurrentConfig()
+	if err != nil {
+		return err
+	}
+	oldDomain := cfg.Domain
+	if oldDomain == "" {
+		oldDomain = "localhost"
+	}
+	if oldDomain == newDomain {
+		green.Println("網域沒有變更。")
+		return nil
+	}
+	if cfg.UseSSL {
+		if err := checkCertificateDomain(newDomain); err != nil {
+			return usageError{msg: fmt.Sprintf("%v；啟用 SSL 時必須使用實際的網域，或先以 %s ssl disable 停用 SSL", err, programName())}
+		}
+	}
+	after := *cfg
+	after.Domain = newDomain
+
+	// 先讀取並計算所有檔案的新內容，任何一個無法處理就不做變更
+	files := []string{targetFile}
+	if cfg.UseSSL {
+		files = append(files, caddyfile)
+	}
+	originals := make(map[string][]byte)
+	for _, path := range files {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("讀取 %s 失敗: %w", path, err)
+		}
+		originals[path] = data
+	}
+	change, err := newSiteURLChange(siteURL(cfg), siteURL(&after))
+	if err != nil {
+		return err
+	}
+	running := siteRunning()
+	if change != nil && !running {
+		return fmt.Errorf("網站未啟動，無法更新 CiviCRM 資料庫中的網址，請先執行 %s start", programName())
+	}
+
+	fmt.Println()
+	cyan.Printf("將網域由 %s 變更為 %s：\n", oldDomain, newDomain)
+	fmt.Printf("  %s: DOMAIN\n", targetFile)
+	if cfg.UseSSL {
+		fmt.Printf("  %s：重新產生，Caddy 會為新網域申請憑證\n", caddyfile)
+	}
+	change.describe()
+	fmt.Println()
+
+	// Caddy 會為新網域申請憑證
+	if cfg.UseSSL {
+		ok, err := confirmDomainDNS(newDomain, cfg.Language, *yes)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			fmt.Println("未變更網域。")
+			return nil
+		}
+	}
+
+	if !*yes {
+		confirm := false
+		prompt := &survey.Confirm{
+			Message: "確定要變更網域嗎？",
+			Default: false,
+		}
+		if err := survey.AskOne(prompt, &confirm); err != nil {
+			return err
+		}
+		if !confirm {
+			fmt.Println("未變更網域。")
+			return nil
+		}
+	}
+
+	beginBackupSet(reasonChangeDomain)
+	set, err := currentBackupSet()
+	if err != nil {
+		return err
+	}
+	// Caddyfile 由 updateCaddyfile 移入備份組
+	if _, err := set.copyIn(targetFile); err != nil {
+		return err
+	}
+	if err := change.backup(set); err != nil {
+		return err
+	}
+
+	services := []string{"php-fpm"}
+	if cfg.UseSSL {
+		services = append(services, "caddy")
+	}
+	recreate := func() error {
+		// php-fpm 的 DOMAIN 環境變數與 Caddy 的設定檔都要重新建立容器才會生效
+		composeArgs := append([]string{"up", "-d", "--no-deps", "--force-recreate"}, services...)
+		return dockerCompose(currentComposeFile(), composeArgs...)
+	}
+
+	// fail 依相反順序還原已完成的步驟
+	recreated := false
+	fail := func(cause error) error {
+		red.Printf("✗ %v\n", cause)
+		yellow.Println("還原所有變更 ...")
+		change.revertDatabase()
+		for _, path := range files {
+			if err := writeFileKeepMode(path, originals[path]); err != nil {
+				red.Printf("✗ 無法還原 %s: %v\n", path, err)
+			}
+		}
+		change.restoreFiles()
+		if recreated {
+			if err := recreate(); err != nil {
+				red.Printf("✗ 無法重新建立 %s: %v\n", strings.Join(services, ", "), err)
+			}
+		}
+		return fmt.Errorf("變更網域失敗，已還原為 %s，原檔備份於 %s", oldDomain, set.dir)
+	}
+
+	if err := updateEnvFile(map[string]string{"DOMAIN": newDomain}); err != nil {
+		return fail(fmt.Errorf("更新 %s 失敗: %w", targetFile, err))
+	}
+	if cfg.UseSSL {
+		if err := updateCaddyfile(&after); err != nil {
+			return fail(fmt.Errorf("更新 Caddyfile 失敗: %w", err))
+		}
+	}
+	if err := change.writeFiles(); err != nil {
+		return fail(err)
+	}
+	green.Printf("✓ 已更新設定檔，原檔備份於 %s\n", set.dir)
+
+	if !running {
+		yellow.Printf("網站目前未啟動，新網域會在下次執行 %s start 時生效。\n", programName())
+		return nil
+	}
+
+	cyan.Printf("重新建立 %s ...\n", strings.Join(services, ", "))
+	recreated = true
+	if err := recreate(); err != nil {
+		return fail(err)
+	}
+	if err := change.updateDatabase(); err != nil {
+		return fail(err)
+	}
+
+	green.Printf("✅ 網域已變更，網站網址：%s\n", siteURL(&after))
+	if cfg.UseSSL {
+		fmt.Println("請確認新網域的 DNS 已指向此主機，Caddy 才能取得憑證。")
+	}
+	return nil
+}
+
+// siteRunning 回傳網站容器是否正在執行
+func siteRunning() bool {
+	return checkDocker() == nil && containerRunning(phpContainer)
+}
+
+// siteURLChange 將網站設定檔與 CiviCRM 資料庫中的網址由 oldURL 改為 newURL，並可還原
+type siteURLChange struct {
+	oldURL, newURL string
+	originals      map[string][]byte
+	rewritten      map[string]string
+	// dbChanged 為 true 時 CiviCRM 資料庫中的網址已被修改
+	dbChanged bool
+}
+
+// newSiteURLChange 讀取網站設定檔並計算新內容；網址沒有變更或網站尚未安裝時回傳 nil
+func newSiteURLChange(oldURL, newURL string) (*siteURLChange, error) {
+	if oldURL == newURL || !fileExists(civicrmSettingsFile) {
+		return nil, nil
+	}
+	c := &siteURLChange{
+		oldURL:    oldURL,
+		newURL:    newURL,
+		originals: make(map[string][]byte),
+		rewritten: make(map[string]string),
+	}
+	for _, path := range siteSettingsFiles {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("讀取 %s 失敗: %w", path, err)
+		}
+		c.originals[path] = data
+		c.rewritten[path] = replaceSiteURL(string(data), oldURL, newURL)
+	}
+	return c, nil
+}
+
+func (c *siteURLChange) describe() {
+	if c == nil {
+		return
+	}
+	fmt.Printf("  %s：網址 %s → %s\n", strings.Join(siteSettingsFiles, "、"), c.oldURL, c.newURL)
+	fmt.Println("  CiviCRM 資料庫中儲存的資源與上傳檔案網址，並執行 drush cr")
+}
+
+// backup 將網站設定檔複製到備份組
+func (c *siteURLChange) backup(set *backupSet) error {
+	if c == nil {
+		return nil
+	}
+	for _, path := range siteSettingsFiles {
+		if _, err := set.copyIn(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *siteURLChange) writeFiles() error {
+	if c == nil {
+		return nil
+	}
+	for _, path := range siteSettingsFiles {
+		if err := writeFileKeepMode(path, []byte(c.rewritten[path])); err != nil {
+			return fmt.Errorf("更新 %s 失敗: %w", path, err)
+		}
+	}
+	if host := urlHost(c.newURL); host != urlHost(c.oldURL) {
+		warnTrustedHost(c.rewritten[drupalSettingsFile], host)
+	}
+	return nil
+}
+
+func (c *siteURLChange) restoreFiles() {
+	if c == nil {
+		return
+	}
+	for _, path := range siteSettingsFiles {
+		if err := writeFileKeepMode(path, c.originals[path]); err != nil {
+			red.Printf("✗ 無法還原 %s: %v\n", path, err)
+		}
+	}
+}
+
+// updateDatabase 等待 Drupal 就緒後更新 CiviCRM 資料庫中的網址並清除快取
+func (c *siteURLChange) updateDatabase() error {
+	if c == nil {
+		return nil
+	}
+	if err := waitForDrupal(2 * time.Minute); err != nil {
+		return err
+	}
+	cyan.Println("更新 CiviCRM 網址設定 ...")
+	c.dbChanged = true
+	if err := rewriteCiviCRMURLs(c.oldURL, c.newURL); err != nil {
+		return fmt.Errorf("更新 CiviCRM 網址失敗: %w", err)
+	}
+	if err := dockerExec(phpContainer, "drush", "--yes", "cr"); err != nil {
+		return fmt.Errorf("drush cr 失敗: %w", err)
+	}
+	return nil
+}
+
+// revertDatabase 將 CiviCRM 資料庫中的網址改回 oldURL
+func (c *siteURLChange) revertDatabase() {
+	if c == nil || !c.dbChanged {
+		return
+	}
+	if err := rewriteCiviCRMURLs(c.newURL, c.oldURL); err != nil {
+		red.Printf("✗ 無法還原 CiviCRM 網址: %v\n", err)
+		return
+	}
+	if err := dockerExec(phpContainer, "drush", "--yes", "cr"); err != nil {
+		red.Printf("✗ drush cr 失敗: %v\n", err)
+	}
+	c.dbChanged = false
+}
+
+// replaceSiteURL 將設定檔中主機與 oldURL 相同的網址改為 newURL 的協定、主機與埠，路徑不變；
+// 主機變更時一併更新 trusted_host_patterns
+func replaceSiteURL(content, oldURL, newURL string) string {
+	oldHost, newHost := urlHost(oldURL), urlHost(newURL)
+	pattern := regexp.MustCompile(`(?im)https?://` + regexp.QuoteMeta(oldHost) + `(:[0-9]+)?([/'"]|$)`)
+	content = pattern.ReplaceAllString(content, newURL+"${2}")
+	if oldHost == newHost {
+		return content
+	}
+	return strings.ReplaceAll(content, trustedHostPattern(oldHost), trustedHostPattern(newHost))
+}
+
+// urlHost 回傳網址的主機名稱
+func urlHost(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
+
+// trustedHostPattern 回傳 settings.php 中 trusted_host_patterns 對應網域的寫法
+func trustedHostPattern(domain string) string {
+	return `'^` + strings.ReplaceAll(domain, ".", `\.`) + `$'`
+}
+
+// warnTrustedHost 在 settings.php 有 trusted_host_patterns 但沒有 domain 時提醒手動加入
+func warnTrustedHost(settings, domain string) {
+	if strings.Contains(settings, "trusted_host_patterns") && !strings.Contains(settings, trustedHostPattern(domain)) {
+		yellow.Printf("%s 的 trusted_host_patterns 沒有 %s，請手動加入 %s\n", drupalSettingsFile, domain, trustedHostPattern(domain))
+	}
+}
+
+// rewriteCiviCRMURLs 將 CiviCRM 資料庫設定中的網址由 oldURL 改為 newURL
+func rewriteCiviCRMURLs(oldURL, newURL string) error {
+	cmd := exec.Command("docker", "exec", "-e", "OLD_HOST="+urlHost(oldURL), "-e", "NEW_URL="+newURL,
+		phpContainer, "drush", "php:eval", civicrmURLScript)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/AlecAivazis/survey/v2"
+)
+
+// 加密後的檔案在原檔名後加上此副檔名
+const encryptedSuffix = ".age"
+
+// ageHeader 是 age 加密檔案的開頭
+var ageHeader = []byte("age-encryption.org/v1\n")
+
+// backupEncryption 記錄備份的加密方式，放在 manifest、說明檔與 backup.json 中，
+// 不必解密即可得知還原時需要哪一把私鑰
+type backupEncryption struct {
+	Method string `json:"method"`
+	// Recipients 為各公鑰的指紋（SHA256:<base64>）
+	Recipients []string `json:"recipients,omitempty"`
+	Passphrase bool     `json:"passphrase,omitempty"`
+}
+
+// backupEncryptor 依 .env 的設定加密備份
+type backupEncryptor struct {
+	recipients []age.Recipient
+	info       *backupEncryption
+}
+
+// unencryptedUploadError 表示未加密的備份不可離開主機，備份內含捐款人等個人資料
+func unencryptedUploadError(target string) error {
+	return fmt.Errorf("備份未加密，拒絕上傳至 %s：請在 .env 設定 BACKUP_AGE_RECIPIENTS 或 BACKUP_AGE_PASSPHRASE，或加上 --allow-unencrypted 明確允許", target)
+}
+
+// newBackupEncryptor 讀取 BACKUP_AGE_RECIPIENTS 或 BACKUP_AGE_PASSPHRASE，兩者皆未設定時回傳 nil
+func newBackupEncryptor(env map[string]string) (*backupEncryptor, error) {
+	keys := splitRecipients(env["BACKUP_AGE_RECIPIENTS"])
+	passphrase := env["BACKUP_AGE_PASSPHRASE"]
+
+	switch {
+	case len(keys) > 0 && passphrase != "":
+		// age 的密碼加密不能與公鑰同時使用
+		return nil, errors.New("BACKUP_AGE_RECIPIENTS 與 BACKUP_AGE_PASSPHRASE 只能設定其中之一")
+	case passphrase != "":
+		r, err := age.NewScryptRecipient(passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("BACKUP_AGE_PASSPHRASE 無效: %w", err)
+		}
+		return &backupEncryptor{
+			recipients: []age.Recipient{r},
+			info:       &backupEncryption{Method: "age", Passphrase: true},
+		}, nil
+	case len(keys) > 0:
+		e := &backupEncryptor{info: &backupEncryption{Method: "age"}}
+		for _, key := range keys {
+			r, err := age.ParseX25519Recipient(key)
+			if err != nil {
+				return nil, fmt.Errorf("BACKUP_AGE_RECIPIENTS 中的公鑰 %q 無效: %w", key, err)
+			}
+			e.recipients = append(e.recipients, r)
+			e.info.Recipients = append(e.info.Recipients, ageFingerprint(r.String()))
+		}
+		return e, nil
+	}
+	return nil, nil
+}
+
+// splitRecipients 拆開以逗號或空白分隔的公鑰清單
+func splitRecipients(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n'
+	})
+}
+
+// validateRecipients 檢查以逗號或空白分隔的 age 公鑰
+func validateRecipients(s string) error {
+	for _, key := range splitRecipients(s) {
+		if _, err := age.ParseX25519Recipient(key); err != nil {
+			return fmt.Errorf("公鑰 %q 無效: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// ageFingerprint 回傳公鑰的指紋，格式與 ssh-keygen -l 相同
+func ageFingerprint(recipient string) string {
+	sum := sha256.Sum256([]byte(recipient))
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
+// encrypt 回傳寫入 w 的加密串流，必須 Close 才會寫完最後一段
+func (e *backupEncryptor) encrypt(w io.Writer) (io.WriteCloser, error) {
+	ew, err := age.Encrypt(w, e.recipients...)
+	if err != nil {
+		return nil, fmt.Errorf("無法建立加密串流: %w", err)
+	}
+	return ew, nil
+}
+
+// describe 回傳給使用者看的加密說明
+func (info *backupEncryption) describe() string {
+	if info.Passphrase {
+		return "age 密碼"
+	}
+	return "age 公鑰 " + strings.Join(info.Recipients, ", ")
+}
+
+// nopWriteCloser 讓未加密時也能以相同方式關閉串流
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// isEncryptedFile 檢查檔案是否以 age 加密
+func isEncryptedFile(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	head := make([]byte, len(ageHeader))
+	n, _ := io.ReadFull(f, head)
+	return bytes.Equal(head[:n], ageHeader), nil
+}
+
+// decryptionIdentities 依序由 --identity、BACKUP_AGE_IDENTITY 與 BACKUP_AGE_PASSPHRASE 取得解密用的私鑰，
+// 都沒有時詢問密碼
+func decryptionIdentities(identityFile string, env map[string]string, info *backupEncryption) ([]age.Identity, error) {
+	if identityFile == "" {
+		identityFile = lookupSetting("BACKUP_AGE_IDENTITY", env)
+	}
+	if identityFile != "" {
+		return readIdentityFile(identityFile)
+	}
+
+	passphrase := lookupSetting("BACKUP_AGE_PASSPHRASE", env)
+	if passphrase == "" {
+		if info != nil && !info.Passphrase {
+			return nil, fmt.Errorf("封存檔以公鑰加密（%s），請以 --identity 或 BACKUP_AGE_IDENTITY 指定私鑰檔", strings.Join(info.Recipients, ", "))
+		}
+		prompt := &survey.Password{Message: "請輸入備份加密密碼："}
+		if err := survey.AskOne(prompt, &passphrase, survey.WithValidator(survey.Required)); err != nil {
+			return nil, err
+		}
+	}
+	id, err := age.NewScryptIdentity(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return []age.Identity{id}, nil
+}
+
+// lookupSetting 優先使用行程環境變數，其次為 .env
+func lookupSetting(key string, env map[string]string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return env[key]
+}
+
+// readIdentityFile 讀取 age-keygen 產生的私鑰檔
+func readIdentityFile(path string) ([]age.Identity, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("無法讀取私鑰檔: %w", err)
+	}
+	defer f.Close()
+
+	ids, err := age.ParseIdentities(bufio.NewReader(f))
+	if err != nil {
+		return nil, fmt.Errorf("無法解析私鑰檔 %s: %w", path, err)
+	}
+	return ids, nil
+}
+
+// identityFingerprints 回傳私鑰對應公鑰的指紋，用於解密失敗時比對
+func identityFingerprints(ids []age.Identity) []string {
+	var fps []string
+	for _, id := range ids {
+		if x, ok := id.(*age.X25519Identity); ok {
+			fps = append(fps, ageFingerprint(x.Recipient().String()))
+		}
+	}
+	return fps
+}
+
+// decrypt 回傳解密後的串流，私鑰不符時說明需要哪一把私鑰
+func decrypt(r io.Reader, ids []age.Identity, info *backupEncryption) (io.Reader, error) {
+	dr, err := age.Decrypt(r, ids...)
+	if err == nil {
+		return dr, nil
+	}
+
+	var noMatch *age.NoIdentityMatchError
+	if errors.As(err, &noMatch) && info != nil && info.Passphrase {
+		return nil, errors.New("備份加密密碼不正確")
+	}
+	if errors.As(err, &noMatch) && info != nil && len(info.Recipients) > 0 {
+		return nil, fmt.Errorf("私鑰不符：封存檔需要 %s，提供的私鑰為 %s",
+			strings.Join(info.Recipients, ", "), strings.Join(identityFingerprints(ids), ", "))
+	}
+	return nil, fmt.Errorf("無法解密封存檔: %w", err)
+}
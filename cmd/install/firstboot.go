@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultInitTimeout 為等待首次啟動初始化的預設時間，包含下載 netiCRM 與 drush site-install
+const defaultInitTimeout = 20 * time.Minute
+
+// initPhases 為 init-10.sh 各階段開始時輸出的訊息
+var initPhases = []struct{ marker, label string }{
+	{"Checking for database connection", "等待資料庫"},
+	{"Downloading Drupal-", "下載 netiCRM"},
+	{"Install Drupal ...", "安裝 Drupal"},
+	{"Enabling netiCRM modules", "啟用 netiCRM 模組"},
+	{"Importing default configuration", "匯入預設設定"},
+}
+
+const (
+	initCompletedMarker = "netiCRM Installation Completed"
+	// initSkippedMarker 表示網站已安裝過，這次啟動不需要初始化
+	initSkippedMarker = "civicrm.settings.php is existed"
+)
+
+// initExitPattern 比對 supervisord 記錄 init 程式結束的訊息
+var initExitPattern = regexp.MustCompile(`exited: init \(exit status ([0-9]+)`)
+
+// initLogState 為掃描 init 日誌的結果
+type initLogState struct {
+	// phase 為目前的階段在 initPhases 中的位置，-1 代表尚未開始
+	phase   int
+	done    bool
+	failure string
+}
+
+// scanInitLog 掃描 neticrm-php 容器的日誌
+func scanInitLog(log string) initLogState {
+	state := initLogState{phase: -1}
+	for _, line := range strings.Split(log, "\n") {
+		line = strings.TrimSpace(line)
+		for i, p := range initPhases {
+			if i > state.phase && strings.Contains(line, p.marker) {
+				state.phase = i
+			}
+		}
+		switch {
+		case strings.Contains(line, initCompletedMarker), strings.Contains(line, initSkippedMarker):
+			state.done = true
+		case strings.HasPrefix(line, "Error:"), strings.HasPrefix(line, "❌"):
+			if state.failure == "" {
+				state.failure = line
+			}
+		}
+		if m := initExitPattern.FindStringSubmatch(line); m != nil && m[1] != "0" && state.failure == "" {
+			state.failure = "init.sh 結束碼 " + m[1]
+		}
+	}
+	return state
+}
+
+// containerStartedAt 回傳容器這次啟動的時間
+func containerStartedAt(name string) (time.Time, error) {
+	out, err := exec.Command("docker", "inspect", "-f", "{{.State.StartedAt}}", name).Output()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("無法取得容器 %s 的狀態: %w", name, err)
+	}
+	return time.Parse(time.RFC3339Nano, strings.TrimSpace(string(out)))
+}
+
+// waitForInit 追蹤 neticrm-php 容器的日誌並顯示 init-10.sh 的進度，直到初始化完成、失敗或逾時
+func waitForInit(timeout time.Duration) error {
+	started, err := containerStartedAt(phpContainer)
+	if err != nil {
+		return err
+	}
+	since := started.Format(time.RFC3339Nano)
+	deadline := time.Now().Add(timeout)
+	logsHint := fmt.Sprintf("請以 %s logs php-fpm 檢視完整紀錄", programName())
+
+	shown := -1
+	lastReport := time.Now()
+	for {
+		if !containerRunning(phpContainer) {
+			return fmt.Errorf("容器 %s 已停止，%s", phpContainer, logsHint)
+		}
+
+		out, err := exec.Command("docker", "logs", "--since", since, phpContainer).CombinedOutput()
+		if err == nil {
+			state := scanInitLog(string(out))
+			for shown < state.phase {
+				shown++
+				fmt.Printf("  [%d/%d] %s ...（%s）\n", shown+1, len(initPhases), initPhases[shown].label, elapsed(started))
+				lastReport = time.Now()
+			}
+			switch {
+			case state.failure != "":
+				return fmt.Errorf("網站初始化失敗：%s，%s", state.failure, logsHint)
+			case state.done:
+				green.Printf("✓ 初始化完成（%s）\n", elapsed(started))
+				return nil
+			case state.phase < 0 && time.Since(started) > 2*time.Minute && fileExists(civicrmSettingsFile):
+				// 舊版的 supervisord 設定不會將 init 的輸出送到容器日誌，網站已安裝時直接檢查網站
+				return nil
+			}
+		}
+
+		if time.Now().After(deadline) {
+			phase := "啟動"
+			if shown >= 0 {
+				phase = initPhases[shown].label
+			}
+			return fmt.Errorf("等待網站初始化逾時（%s，停在「%s」），%s", timeout, phase, logsHint)
+		}
+		if time.Since(lastReport) >= time.Minute {
+			fmt.Printf("  仍在進行中 ...（%s）\n", elapsed(started))
+			lastReport = time.Now()
+		}
+		time.Sleep(3 * time.Second)
+	}
+}
+
+// elapsed 回傳由 since 到現在經過的時間，例如 3 分 05 秒
+func elapsed(since time.Time) string {
+	d := time.Since(since).Round(time.Second)
+	if d < time.Minute {
+		return strconv.Itoa(int(d.Seconds())) + " 秒"
+	}
+	return fmt.Sprintf("%d 分 %02d 秒", int(d.Minutes()), int(d.Seconds())%60)
+}
+
+// waitForSite 等待首次啟動初始化完成，並確認網站可以正常回應
+func waitForSite(timeout time.Duration) error {
+	cfg, err := loadCurrentConfig()
+	if err != nil {
+		return err
+	}
+
+	cyan.Println("等待網站初始化（首次啟動會下載 netiCRM 並安裝 Drupal，需要數分鐘）...")
+	if err := waitForInit(timeout); err != nil {
+		return err
+	}
+	if _, err := captureAdminPassword(); err != nil {
+		yellow.Printf("⚠️  %v\n", err)
+	}
+	if err := waitForDrupal(2 * time.Minute); err != nil {
+		return err
+	}
+	if err := smokeTest(cfg, 2*time.Minute); err != nil {
+		return err
+	}
+
+	green.Printf("✅ 網站已可使用：%s\n", siteURL(cfg))
+	forgetAdminPasswordAfterLogin()
+	return nil
+}
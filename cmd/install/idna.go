@@ -0,0 +1,195 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// normalizeDomain 檢查網域名稱的格式並轉為小寫的 ASCII 形式；中文等國際化網域（IDN）的標籤會轉為 punycode（xn--）
+func normalizeDomain(domain string) (string, error) {
+	domain = strings.TrimSpace(domain)
+	if domain == "" {
+		return "", errors.New("網域不可為空白")
+	}
+	if strings.ContainsAny(domain, " \t/:@$'\"\\") {
+		return "", fmt.Errorf("無效的網域 %q，請只輸入主機名稱，例如 crm.example.org", domain)
+	}
+	if ip := net.ParseIP(domain); ip != nil {
+		return ip.String(), nil
+	}
+
+	// 與瀏覽器相同，全形字元轉為半形，中文句號也視為分隔的點
+	name := strings.ToLower(norm.NFKC.String(domain))
+	name = strings.NewReplacer("。", ".", "｡", ".").Replace(name)
+	name = strings.TrimSuffix(name, ".")
+
+	labels := strings.Split(name, ".")
+	for i, label := range labels {
+		if label == "" {
+			return "", fmt.Errorf("無效的網域 %q：不可有連續的點或以點開頭", domain)
+		}
+		if strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return "", fmt.Errorf("無效的網域 %q：%s 不可以 - 開頭或結尾", domain, label)
+		}
+		ascii := true
+		for _, r := range label {
+			switch {
+			case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-':
+			case r >= 0x80 && (unicode.IsLetter(r) || unicode.IsMark(r) || unicode.IsDigit(r)):
+				ascii = false
+			default:
+				return "", fmt.Errorf("無效的網域 %q：不可包含 %q", domain, r)
+			}
+		}
+		if !ascii {
+			label = "xn--" + punycodeEncode(label)
+			labels[i] = label
+		}
+		if len(label) > 63 {
+			return "", fmt.Errorf("無效的網域 %q：%s 超過 63 個字元", domain, label)
+		}
+	}
+	name = strings.Join(labels, ".")
+	if len(name) > 253 {
+		return "", fmt.Errorf("無效的網域 %q：超過 253 個字元", domain)
+	}
+	if tld := labels[len(labels)-1]; strings.Trim(tld, "0123456789") == "" {
+		return "", fmt.Errorf("無效的網域 %q：最後一段不可全為數字", domain)
+	}
+	return name, nil
+}
+
+// checkCertificateDomain 確認網域可以向 Let's Encrypt 申請憑證：必須是公開的網域名稱，不可為 IP 位址或 localhost
+func checkCertificateDomain(domain string) error {
+	if net.ParseIP(domain) != nil {
+		return fmt.Errorf("Let's Encrypt 無法為 IP 位址 %s 簽發憑證，請使用網域名稱", domain)
+	}
+	if domain == "localhost" || strings.HasSuffix(domain, ".localhost") || strings.HasSuffix(domain, ".local") {
+		return fmt.Errorf("Let's Encrypt 無法為 %s 簽發憑證，請使用公開的網域名稱", domain)
+	}
+	if !strings.Contains(domain, ".") {
+		return fmt.Errorf("%s 不是完整的網域名稱，請輸入例如 crm.example.org", domain)
+	}
+	return nil
+}
+
+// normalizeEmail 檢查 Let's Encrypt 通知用的電子郵件格式，國際化網域的部分轉為 punycode
+func normalizeEmail(email string) (string, error) {
+	email = strings.TrimSpace(email)
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Name != "" || addr.Address != email {
+		return "", fmt.Errorf("無效的電子郵件 %q，請只輸入信箱，例如 admin@example.org", email)
+	}
+	at := strings.LastIndex(email, "@")
+	local, domain := email[:at], email[at+1:]
+	for _, r := range local {
+		if r >= 0x80 {
+			return "", fmt.Errorf("無效的電子郵件 %q：Let's Encrypt 不接受 @ 前含非英數字元的信箱", email)
+		}
+	}
+	domain, err = normalizeDomain(domain)
+	if err != nil {
+		return "", fmt.Errorf("無效的電子郵件 %q：%w", email, err)
+	}
+	if !strings.Contains(domain, ".") || net.ParseIP(domain) != nil {
+		return "", fmt.Errorf("無效的電子郵件 %q：@ 後必須是完整的網域名稱", email)
+	}
+	return local + "@" + domain, nil
+}
+
+// punycode 參數，見 RFC 3492
+const (
+	punyBase        = 36
+	punyTMin        = 1
+	punyTMax        = 26
+	punySkew        = 38
+	punyDamp        = 700
+	punyInitialBias = 72
+	punyInitialN    = 128
+)
+
+// punycodeEncode 依 RFC 3492 將一段網域標籤編碼為 punycode，不含 xn-- 前綴
+func punycodeEncode(label string) string {
+	runes := []rune(label)
+	var out []byte
+	for _, r := range runes {
+		if r < 0x80 {
+			out = append(out, byte(r))
+		}
+	}
+	basic := len(out)
+	handled := basic
+	if basic > 0 {
+		out = append(out, '-')
+	}
+
+	n, delta, bias := rune(punyInitialN), 0, punyInitialBias
+	for handled < len(runes) {
+		// 下一個要編碼的字元為尚未處理中最小的
+		m := rune(unicode.MaxRune)
+		for _, r := range runes {
+			if r >= n && r < m {
+				m = r
+			}
+		}
+		delta += int(m-n) * (handled + 1)
+		n = m
+		for _, r := range runes {
+			if r < n {
+				delta++
+			}
+			if r != n {
+				continue
+			}
+			q := delta
+			for k := punyBase; ; k += punyBase {
+				t := k - bias
+				if t < punyTMin {
+					t = punyTMin
+				} else if t > punyTMax {
+					t = punyTMax
+				}
+				if q < t {
+					break
+				}
+				out = append(out, punyDigit(t+(q-t)%(punyBase-t)))
+				q = (q - t) / (punyBase - t)
+			}
+			out = append(out, punyDigit(q))
+			bias = punyAdapt(delta, handled+1, handled == basic)
+			delta = 0
+			handled++
+		}
+		delta++
+		n++
+	}
+	return string(out)
+}
+
+func punyDigit(d int) byte {
+	if d < 26 {
+		return byte('a' + d)
+	}
+	return byte('0' + d - 26)
+}
+
+func punyAdapt(delta, points int, first bool) int {
+	if first {
+		delta /= punyDamp
+	} else {
+		delta /= 2
+	}
+	delta += delta / points
+	k := 0
+	for delta > (punyBase-punyTMin)*punyTMax/2 {
+		delta /= punyBase - punyTMin
+		k += punyBase
+	}
+	return k + (punyBase-punyTMin+1)*delta/(delta+punySkew)
+}
@@ -0,0 +1,46 @@
+package main
+
+import "testing"
+
+func TestNormalizeDomain(t *testing.T) {
+	tests := []struct {
+		domain  string
+		want    string
+		wantErr bool
+	}{
+		{domain: "crm.example.org", want: "crm.example.org"},
+		{domain: "  CRM.Example.ORG ", want: "crm.example.org"},
+		{domain: "crm.example.org.", want: "crm.example.org"},
+		{domain: "Bücher.example", want: "xn--bcher-kva.example"},
+		{domain: "münchen-ost.de", want: "xn--mnchen-ost-9db.de"},
+		{domain: "台灣.tw", want: "xn--kpry57d.tw"},
+		{domain: "台灣.tw.", want: "xn--kpry57d.tw"},
+		{domain: "Ελληνικά.gr", want: "xn--hxargifdar.gr"},
+		// 全形字元與中文句號
+		{domain: "ｃｒｍ．例え．jp", want: "crm.xn--r8jz45g.jp"},
+		{domain: "crm。example。org", want: "crm.example.org"},
+		{domain: "xn--bcher-kva.example", want: "xn--bcher-kva.example"},
+		{domain: "192.0.2.10", want: "192.0.2.10"},
+		{domain: "2001:db8::1", wantErr: true},
+		{domain: "", wantErr: true},
+		{domain: "https://crm.example.org", wantErr: true},
+		{domain: "crm.example.org:8080", wantErr: true},
+		{domain: "a_b.org", wantErr: true},
+		{domain: "-a.org", wantErr: true},
+		{domain: "a-.org", wantErr: true},
+		{domain: "x..y", wantErr: true},
+		{domain: ".example.org", wantErr: true},
+		{domain: "1.2.3", wantErr: true},
+		{domain: "crm.example.org..", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := normalizeDomain(tt.domain)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("normalizeDomain(%q) error = %v, wantErr %v", tt.domain, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("normalizeDomain(%q) = %q, want %q", tt.domain, got, tt.want)
+		}
+	}
+}
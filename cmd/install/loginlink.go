@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultLoginLinkTTL 為 Drupal 一次性登入連結的預設有效時間（user.settings 的 password_reset_timeout）
+const defaultLoginLinkTTL = 24 * time.Hour
+
+// loginLinkTTLScript 回傳一次性登入連結的有效秒數
+const loginLinkTTLScript = `echo \Drupal::config('user.settings')->get('password_reset_timeout');`
+
+func runLoginLink(args []string) error {
+	fs := newFlagSet("login-link")
+	user := fs.String("user", "", "登入的帳號名稱（預設為 .env 的 ADMIN_LOGIN_USER）")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := noArgs(fs); err != nil {
+		return err
+	}
+	if err := requireInstalled(); err != nil {
+		return err
+	}
+	if err := checkDocker(); err != nil {
+		return err
+	}
+	if !containerRunning(phpContainer) {
+		return fmt.Errorf("容器 %s 未執行，請先執行 %s start", phpContainer, programName())
+	}
+
+	cfg, err := loadCurrentConfig()
+	if err != nil {
+		return err
+	}
+	name := *user
+	if name == "" {
+		name = loginUser(cfg)
+	}
+
+	link, err := loginLink(cfg, name)
+	if err != nil {
+		return err
+	}
+	printLoginLink(name, link)
+	return nil
+}
+
+// initAdminUser 為 ADMIN_LOGIN_USER 空白時 container/init-10.sh 建立的管理員帳號
+const initAdminUser = "neticrm_admin"
+
+// loginUser 回傳 .env 設定的管理員帳號
+func loginUser(cfg *Config) string {
+	return adminUserOrDefault(cfg.AdminLoginUser)
+}
+
+// adminUserOrDefault 回傳 ADMIN_LOGIN_USER 的值，空白時與安裝網站時相同使用 initAdminUser
+func adminUserOrDefault(user string) string {
+	if user == "" {
+		return initAdminUser
+	}
+	return user
+}
+
+// loginLink 以 drush user:login 產生指定帳號的一次性登入連結，網址的協定與埠依目前的 SSL 模式與 HTTP_PORT
+func loginLink(cfg *Config, user string) (string, error) {
+	out, err := exec.Command("docker", "exec", phpContainer,
+		"drush", "--uri="+siteURL(cfg), "user:login", "--name="+user, "--no-browser").CombinedOutput()
+	if err != nil {
+		msg := strings.TrimSpace(string(out))
+		if msg == "" {
+			msg = err.Error()
+		}
+		return "", fmt.Errorf("產生帳號 %s 的登入連結失敗: %s", user, msg)
+	}
+
+	// drush 可能在連結前輸出警告，取最後一個網址
+	var link string
+	for _, line := range strings.Split(string(out), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "http://") || strings.HasPrefix(line, "https://") {
+			link = line
+		}
+	}
+	if link == "" {
+		return "", fmt.Errorf("drush 沒有回傳登入連結: %s", strings.TrimSpace(string(out)))
+	}
+	return link, nil
+}
+
+// loginLinkTTL 回傳網站設定的一次性登入連結有效時間，無法查詢時回傳 Drupal 的預設值
+func loginLinkTTL() time.Duration {
+	out, err := exec.Command("docker", "exec", phpContainer, "drush", "php:eval", loginLinkTTLScript).Output()
+	if err != nil {
+		return defaultLoginLinkTTL
+	}
+	seconds, err := strconv.Atoi(strings.TrimSpace(string(out)))
+	if err != nil || seconds <= 0 {
+		return defaultLoginLinkTTL
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// printLoginLink 顯示登入連結與使用限制
+func printLoginLink(user, link string) {
+	green.Printf("帳號 %s 的一次性登入連結：\n", user)
+	fmt.Println(link)
+	expires := time.Now().Add(loginLinkTTL())
+	yellow.Printf("連結只能使用一次，並於 %s 失效；登入後請在個人資料頁設定密碼。\n", expires.Format("2006-01-02 15:04"))
+}
@@ -2,15 +2,17 @@ package main
 
 import (
 	"crypto/rand"
+	"errors"
 	"fmt"
 	"math/big"
 	"os"
 	"os/exec"
 	"strings"
+	"time"
 
 	"github.com/AlecAivazis/survey/v2"
 	"github.com/fatih/color"
-	"github.com/joho/godotenv"
+	"github.com/netivism/neticrm-selfhost/internal/envfile"
 )
 
 const (
@@ -20,22 +22,39 @@ const (
 	sslComposeFile     = "docker-compose-ssl.yaml"
 	caddyfile          = "data/Caddyfile"
 	exampleCaddyfile   = "data/example.Caddyfile"
+	mariadbContainer   = "neticrm-mariadb"
+	phpContainer       = "neticrm-php"
 )
 
+// version 為安裝程式版本，發佈時以 -ldflags "-X main.version=..." 設定
+var version = "dev"
+
 // Config 保存所有配置
 type Config struct {
-	Language           string
-	Domain             string
-	Email              string
-	UseSSL             bool
-	Port               string
+	Language string
+	Domain   string
+	Email    string
+	UseSSL   bool
+	// ACMECA 為 Caddy 申請憑證的 ACME 目錄，空白代表 Let's Encrypt 正式環境
+	ACMECA string
+	// ACMECARoot 為自訂 ACME CA 的根憑證檔案
+	ACMECARoot string
+	Port       string
+	// HTTPBind 為 nginx 發布 HTTP_PORT 的位址，空白代表所有網路介面
+	HTTPBind           string
 	MySQLRootPassword  string
 	MySQLDatabase      string
 	MySQLUser          string
 	MySQLPassword      string
 	AdminLoginUser     string
 	AdminLoginPassword string
-	envVars            map[string]string
+	NeticrmVersion     string
+	BackupRecipients   string
+	// InitTimeout 為等待首次啟動初始化的時間，0 代表不等待
+	InitTimeout time.Duration
+	envVars     map[string]string
+	// baseEnv 為覆蓋設定前的 .env，寫入時以它為基礎
+	baseEnv *envfile.File
 }
 
 var (
@@ -47,45 +66,72 @@ var (
 )
 
 func main() {
+	os.Exit(runCLI(os.Args[1:]))
+}
+
+// runInstall 執行安裝精靈：檢查、詢問、執行
+func runInstall(args []string) error {
+	fs := newFlagSet("install")
+	fromFlags := registerAnswerFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := noArgs(fs); err != nil {
+		return err
+	}
+
+	ans, err := resolveAnswers(fromFlags)
+	if err != nil {
+		return usageError{msg: fmt.Sprintf("讀取設定失敗: %v", err)}
+	}
+	if ans.NonInteractive {
+		if err := ans.validate(); err != nil {
+			return err
+		}
+	}
+
 	bold.Println("netiCRM Self-Host 自架站台安裝程式")
 	fmt.Println()
 
 	// 檢查階段
-	if err := goCheck(); err != nil {
-		red.Printf("✗ 檢查失敗: %v\n", err)
-		os.Exit(1)
+	existingEnv, err := goCheck(ans)
+	if err != nil {
+		return fmt.Errorf("檢查失敗: %w", err)
 	}
 
 	// 詢問階段
-	cfg, err := goAsk()
+	cfg, err := goAsk(ans, existingEnv)
 	if err != nil {
-		red.Printf("✗ 設定失敗: %v\n", err)
-		os.Exit(1)
+		return fmt.Errorf("設定失敗: %w", err)
 	}
 
 	// 執行階段
 	if err := goRun(cfg); err != nil {
-		red.Printf("✗ 執行失敗: %v\n", err)
-		os.Exit(1)
+		return fmt.Errorf("執行失敗: %w", err)
 	}
 
 	green.Println("✅ 安裝完成！")
+	return nil
 }
 
-// goCheck 進行所有事前檢查
-func goCheck() error {
+// goCheck 進行所有事前檢查，選擇覆蓋設定時回傳原本的 .env
+func goCheck(ans *answers) (*envfile.File, error) {
 	// 檢查是否有 .env 和資料庫檔案
 	hasEnv := fileExists(targetFile)
 	hasMariaDBData := checkMariaDBData()
 
+	var existingEnv *envfile.File
+	if hasEnv {
+		existingEnv = loadExistingEnv()
+	}
+
 	if hasEnv && hasMariaDBData {
 		yellow.Println("發現現有的資料庫檔案，看起來這是一個已經安裝好的網站。")
 
-		// 讀取現有配置
-		existingEnv, _ := godotenv.Read(targetFile)
-		domain := existingEnv["DOMAIN"]
-		port := existingEnv["HTTP_PORT"]
-		adminUser := existingEnv["ADMIN_LOGIN_USER"]
+		// 現有配置
+		domain, _ := existingEnv.Get("DOMAIN")
+		port, _ := existingEnv.Get("HTTP_PORT")
+		adminUser, _ := existingEnv.Get("ADMIN_LOGIN_USER")
 
 		// 如果有 Caddyfile，嘗試從中獲取域名
 		if fileExists(caddyfile) {
@@ -106,50 +152,102 @@ func goCheck() error {
 			fmt.Printf("  管理員帳號: %s\n", adminUser)
 		}
 		fmt.Println()
+		fmt.Printf("只要修改網域、埠或 SSL 設定時，請執行 %s reconfigure，不需要覆蓋安裝。\n\n", programName())
 
 		options := []string{
 			"1. 執行 docker 啟動指令（若已啟動則不影響）",
 			"2. 備份網站檔案並覆蓋設定",
-			"3. 檢視初始設定管理員密碼 ADMIN_LOGIN_PASSWORD",
-			"4. 結束安裝",
+			"3. 產生管理員一次性登入連結（建議）",
+			"4. 檢視初始設定管理員密碼 ADMIN_LOGIN_PASSWORD",
+			"5. 結束安裝",
 		}
 
 		var choice string
-		prompt := &survey.Select{
-			Message: "請選擇操作（上下鍵選取，或按下數字鍵後 enter）：",
-			Options: options,
-		}
-		if err := survey.AskOne(prompt, &choice); err != nil {
-			return err
+		if ans.NonInteractive {
+			action, err := ans.existingAction(existingStart, existingOverwrite, existingLoginLink, existingShowPassword, existingExit)
+			if err != nil {
+				return nil, err
+			}
+			switch action {
+			case existingStart:
+				choice = options[0]
+			case existingOverwrite:
+				choice = options[1]
+			case existingLoginLink:
+				choice = options[2]
+			case existingShowPassword:
+				choice = options[3]
+			case existingExit:
+				choice = options[4]
+			}
+		} else {
+			prompt := &survey.Select{
+				Message: "請選擇操作（上下鍵選取，或按下數字鍵後 enter）：",
+				Options: options,
+			}
+			if err := survey.AskOne(prompt, &choice); err != nil {
+				return nil, err
+			}
 		}
 
 		switch choice {
 		case options[0]: // 執行 docker 啟動指令
-			return startDocker()
+			timeout, _ := ans.waitTimeout()
+			if err := startDocker(timeout); err != nil {
+				return nil, err
+			}
+			os.Exit(0)
 		case options[1]: // 備份並覆蓋配置
-			if err := backupExisting(); err != nil {
-				return err
+			beginBackupSet(reasonOverwrite)
+			if err := backupExisting(ans); err != nil {
+				return nil, err
 			}
-		case options[2]: // 檢視密碼
-			yellow.Println("⚠️  注意：此會用明文顯示初始密碼，且可能已更改")
-			var confirmShow bool
-			confirmPrompt := &survey.Confirm{
-				Message: "確定要顯示密碼嗎？",
-				Default: false,
+		case options[2]: // 一次性登入連結
+			if !siteRunning() {
+				return nil, fmt.Errorf("網站未啟動，請先選擇「%s」或執行 %s start", options[0], programName())
 			}
-			if err := survey.AskOne(confirmPrompt, &confirmShow); err != nil {
-				return err
+			cfg, err := loadCurrentConfig()
+			if err != nil {
+				return nil, err
+			}
+			link, err := loginLink(cfg, loginUser(cfg))
+			if err != nil {
+				return nil, err
+			}
+			printLoginLink(loginUser(cfg), link)
+			os.Exit(0)
+		case options[3]: // 檢視密碼
+			yellow.Println("⚠️  注意：此會用明文顯示初始密碼，且可能已更改；建議改用一次性登入連結")
+			confirmShow := ans.NonInteractive
+			if !ans.NonInteractive {
+				confirmPrompt := &survey.Confirm{
+					Message: "確定要顯示密碼嗎？",
+					Default: false,
+				}
+				if err := survey.AskOne(confirmPrompt, &confirmShow); err != nil {
+					return nil, err
+				}
 			}
 
 			if confirmShow {
-				if pass := existingEnv["ADMIN_LOGIN_PASSWORD"]; pass != "" {
+				// 先取回初始化程式產生的密碼，管理員已登入過則不再保留
+				if _, err := captureAdminPassword(); err != nil {
+					yellow.Printf("⚠️  %v\n", err)
+				}
+				forgot := forgetAdminPasswordAfterLogin()
+				if env := loadExistingEnv(); env != nil {
+					existingEnv = env
+				}
+				if pass, _ := existingEnv.Get("ADMIN_LOGIN_PASSWORD"); pass != "" {
 					fmt.Printf("ADMIN_LOGIN_PASSWORD: %s\n", pass)
+				} else if forgot {
+					fmt.Printf("請使用 %s login-link 取得一次性登入連結。\n", programName())
 				} else {
 					fmt.Println("密碼未設定或為空")
 				}
 			}
 			os.Exit(0)
-		case options[3]: // 結束安裝
+		case options[4]: // 結束安裝
 			fmt.Println("安裝取消。")
 			os.Exit(0)
 		}
@@ -157,11 +255,10 @@ func goCheck() error {
 		// 只有 .env 沒有資料庫
 		yellow.Println("發現現有的 .env 檔案")
 
-		// 讀取並顯示現有配置
-		existingEnv, _ := godotenv.Read(targetFile)
-		domain := existingEnv["DOMAIN"]
-		port := existingEnv["HTTP_PORT"]
-		adminUser := existingEnv["ADMIN_LOGIN_USER"]
+		// 顯示現有配置
+		domain, _ := existingEnv.Get("DOMAIN")
+		port, _ := existingEnv.Get("HTTP_PORT")
+		adminUser, _ := existingEnv.Get("ADMIN_LOGIN_USER")
 
 		// 如果有 Caddyfile，優先使用其中的域名
 		if fileExists(caddyfile) {
@@ -186,12 +283,20 @@ func goCheck() error {
 		}
 
 		var overwrite bool
-		prompt := &survey.Confirm{
-			Message: "是否要更改設定？(舊的 .env 檔會改名備份)",
-			Default: false,
-		}
-		if err := survey.AskOne(prompt, &overwrite); err != nil {
-			return err
+		if ans.NonInteractive {
+			action, err := ans.existingAction(existingOverwrite, existingExit)
+			if err != nil {
+				return nil, err
+			}
+			overwrite = action == existingOverwrite
+		} else {
+			prompt := &survey.Confirm{
+				Message: "是否要更改設定？(舊的 .env 檔會改名備份)",
+				Default: false,
+			}
+			if err := survey.AskOne(prompt, &overwrite); err != nil {
+				return nil, err
+			}
 		}
 
 		if !overwrite {
@@ -199,8 +304,9 @@ func goCheck() error {
 			os.Exit(0)
 		}
 
+		beginBackupSet(reasonOverwrite)
 		if err := backupFile(targetFile); err != nil {
-			return err
+			return nil, err
 		}
 	}
 
@@ -208,13 +314,15 @@ func goCheck() error {
 	if err := checkDocker(); err != nil {
 		yellow.Printf("⚠️  %v\n", err)
 
-		var proceed bool
-		prompt := &survey.Confirm{
-			Message: "是否要繼續僅更改 .env 檔案？",
-			Default: false,
-		}
-		if err := survey.AskOne(prompt, &proceed); err != nil {
-			return err
+		proceed := ans.envOnly()
+		if !ans.NonInteractive {
+			prompt := &survey.Confirm{
+				Message: "是否要繼續僅更改 .env 檔案？",
+				Default: false,
+			}
+			if err := survey.AskOne(prompt, &proceed); err != nil {
+				return nil, err
+			}
 		}
 
 		if !proceed {
@@ -231,13 +339,33 @@ func goCheck() error {
 		}
 	}
 
-	return nil
+	return existingEnv, nil
+}
+
+// loadExistingEnv 讀取現有的 .env，無法解析時回傳 nil，覆蓋設定時改以 example.env 為基礎
+func loadExistingEnv() *envfile.File {
+	f, err := envfile.Load(targetFile)
+	if err != nil {
+		yellow.Printf("⚠️  無法解析現有的 %s: %v\n", targetFile, err)
+		return nil
+	}
+	return f
 }
 
 // goAsk 進行所有互動詢問
-func goAsk() (*Config, error) {
+func goAsk(ans *answers, existingEnv *envfile.File) (*Config, error) {
 	cfg := &Config{
 		envVars: make(map[string]string),
+		baseEnv: existingEnv,
+	}
+	timeout, err := ans.waitTimeout()
+	if err != nil {
+		return nil, err
+	}
+	cfg.InitTimeout = timeout
+	cfg.ACMECA, cfg.ACMECARoot = ans.ACMECA, ans.ACMECARoot
+	if err := validateACMECA(nil, cfg); err != nil {
+		return nil, err
 	}
 
 	// 載入預設環境變數
@@ -245,6 +373,20 @@ func goAsk() (*Config, error) {
 		return nil, err
 	}
 
+	// 非互動模式直接使用已驗證的回答
+	if ans.NonInteractive {
+		ans.toConfig(cfg)
+		if err := ans.checkPorts(cfg); err != nil {
+			return nil, err
+		}
+		if cfg.UseSSL {
+			// 非互動模式無法詢問，DNS 未指向此主機時只警告
+			confirmDomainDNS(cfg.Domain, cfg.Language, true)
+		}
+		pinNeticrmVersion(cfg)
+		return cfg, nil
+	}
+
 	// 1. 語言選擇
 	if err := askLanguage(cfg); err != nil {
 		return nil, err
@@ -262,8 +404,21 @@ func goAsk() (*Config, error) {
 		cyan.Println("or bind to a specific port according to your chosen settings.")
 	}
 
-	if err := askDomainAndSSL(cfg); err != nil {
-		return nil, err
+	// 申請憑證前確認 DNS 已指向此主機，選擇不繼續時重新詢問網域
+	for {
+		if err := askDomainAndSSL(cfg); err != nil {
+			return nil, err
+		}
+		if !cfg.UseSSL {
+			break
+		}
+		ok, err := confirmDomainDNS(cfg.Domain, cfg.Language, false)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			break
+		}
 	}
 
 	// 3. MySQL 設定
@@ -276,6 +431,11 @@ func goAsk() (*Config, error) {
 		return nil, err
 	}
 
+	// 5. netiCRM 版本
+	if err := askNeticrmVersion(cfg); err != nil {
+		return nil, err
+	}
+
 	return cfg, nil
 }
 
@@ -283,17 +443,20 @@ func goAsk() (*Config, error) {
 func goRun(cfg *Config) error {
 	// 設定環境變數
 	cfg.envVars["LANGUAGE"] = cfg.Language
+	cfg.envVars["SSL_MODE"] = sslMode(cfg.UseSSL)
 
+	cfg.envVars["DOMAIN"] = cfg.Domain
+	if cfg.Domain == "" {
+		cfg.envVars["DOMAIN"] = "localhost"
+	}
 	if !cfg.UseSSL {
-		if cfg.Domain != "" {
-			cfg.envVars["DOMAIN"] = cfg.Domain
-			cfg.envVars["HTTP_PORT"] = ""
-		} else {
-			cfg.envVars["DOMAIN"] = "localhost"
-			cfg.envVars["HTTP_PORT"] = cfg.Port
-		}
+		cfg.envVars["HTTP_PORT"] = cfg.Port
+		// 空白代表綁定所有介面，覆蓋設定時不保留原本的位址
+		cfg.envVars["HTTP_BIND"] = cfg.HTTPBind
 	} else {
-		cfg.envVars["HTTP_PORT"] = ""
+		// 使用 SSL 時由 Caddy 對外，保留原本的 HTTP_PORT 與 HTTP_BIND 以便之後停用 SSL
+		delete(cfg.envVars, "HTTP_PORT")
+		delete(cfg.envVars, "HTTP_BIND")
 	}
 
 	// MySQL 設定
@@ -310,6 +473,14 @@ func goRun(cfg *Config) error {
 	cfg.envVars["ADMIN_LOGIN_USER"] = cfg.AdminLoginUser
 	cfg.envVars["ADMIN_LOGIN_PASSWORD"] = cfg.AdminLoginPassword
 
+	// netiCRM 版本，留空時首次啟動安裝最新版
+	cfg.envVars["NETICRM_VERSION"] = cfg.NeticrmVersion
+
+	// 備份加密
+	if cfg.BackupRecipients != "" {
+		cfg.envVars["BACKUP_AGE_RECIPIENTS"] = cfg.BackupRecipients
+	}
+
 	// 寫入 .env
 	if err := writeEnvFile(cfg); err != nil {
 		return fmt.Errorf("寫入 .env 失敗: %w", err)
@@ -342,9 +513,24 @@ func goRun(cfg *Config) error {
 	if err := dockerComposeUp(composeFile); err != nil {
 		return err
 	}
+	if cfg.InitTimeout <= 0 {
+		cyan.Println("服務已啟動，網站會在背景初始化，可使用以下指令查看日誌：")
+		fmt.Printf("%s logs --follow php-fpm\n", programName())
+		return nil
+	}
 
-	cyan.Println("服務已啟動，可使用以下指令查看日誌：")
-	fmt.Printf("docker compose -f %s logs -f\n", composeFile)
+	// 確認網站可以使用後才顯示登入資訊
+	if err := waitForSite(cfg.InitTimeout); err != nil {
+		return err
+	}
+	fmt.Printf("管理員帳號：%s\n", cfg.AdminLoginUser)
+	if env, err := envfile.ReadFile(targetFile); err == nil && env["ADMIN_LOGIN_PASSWORD"] != "" {
+		// 密碼空白時由初始化程式產生，waitForSite 已將它存入 .env
+		fmt.Printf("管理員密碼：%s\n", env["ADMIN_LOGIN_PASSWORD"])
+		fmt.Println("首次登入後，安裝程式會將密碼自 .env 移除。")
+	} else {
+		fmt.Printf("管理員密碼由初始化程式產生，請執行 %s login-link 取得一次性登入連結\n", programName())
+	}
 
 	return nil
 }
@@ -418,24 +604,39 @@ func getDomainFromCaddyfile() string {
 	return ""
 }
 
-func backupFile(path string) error {
-	backupPath := path + ".bak"
-	count := 0
+// getEmailFromCaddyfile 讀取 Caddyfile 全域設定中的 email
+func getEmailFromCaddyfile() string {
+	data, err := os.ReadFile(caddyfile)
+	if err != nil {
+		return ""
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "email" {
+			return fields[1]
+		}
+	}
+	return ""
+}
 
-	for fileExists(backupPath) {
-		count++
-		backupPath = fmt.Sprintf("%s.bak%d", path, count)
+// backupFile 將檔案或目錄移入本次操作的備份組
+func backupFile(path string) error {
+	set, err := currentBackupSet()
+	if err != nil {
+		return err
 	}
 
-	if err := os.Rename(path, backupPath); err != nil {
-		return fmt.Errorf("無法備份 %s: %v", path, err)
+	backupPath, err := set.moveIn(path)
+	if err != nil {
+		return err
 	}
 
-	green.Printf("已將 %s 備份為 %s\n", path, backupPath)
+	green.Printf("已將 %s 備份至 %s\n", path, backupPath)
 	return nil
 }
 
-func backupExisting() error {
+func backupExisting(ans *answers) error {
 	// 備份 .env
 	if err := backupFile(targetFile); err != nil {
 		return err
@@ -443,13 +644,15 @@ func backupExisting() error {
 
 	// 詢問是否備份資料庫
 	if checkMariaDBData() {
-		var backupDB bool
-		prompt := &survey.Confirm{
-			Message: "是否要備份資料庫、網站檔案（data/mariadb_data、data/www 資料夾）？",
-			Default: true,
-		}
-		if err := survey.AskOne(prompt, &backupDB); err != nil {
-			return err
+		backupDB := ans.backupData()
+		if !ans.NonInteractive {
+			prompt := &survey.Confirm{
+				Message: "是否要備份資料庫、網站檔案（data/mariadb_data、data/www 資料夾）？",
+				Default: true,
+			}
+			if err := survey.AskOne(prompt, &backupDB); err != nil {
+				return err
+			}
 		}
 
 		if backupDB {
@@ -469,19 +672,22 @@ func backupExisting() error {
 	return nil
 }
 
-func startDocker() error {
-	hasCaddyfile := fileExists(caddyfile)
-
-	var composeFile string
-	if hasCaddyfile {
+// startDocker 啟動網站，timeout 大於 0 時等待初始化完成並確認網站可以使用
+func startDocker(timeout time.Duration) error {
+	composeFile := currentComposeFile()
+	if composeFile == sslComposeFile {
 		cyan.Println("使用 SSL 配置啟動...")
-		composeFile = sslComposeFile
 	} else {
 		cyan.Println("使用非 SSL 配置啟動...")
-		composeFile = defaultComposeFile
 	}
 
-	return dockerComposeUp(composeFile)
+	if err := dockerComposeUp(composeFile); err != nil {
+		return err
+	}
+	if timeout <= 0 {
+		return nil
+	}
+	return waitForSite(timeout)
 }
 
 func checkDocker() error {
@@ -505,30 +711,17 @@ func dockerComposeUp(composeFile string) error {
 		return fmt.Errorf("執行 docker compose up 失敗: %w", err)
 	}
 
-	green.Println("網站已成功啟動！")
-	os.Exit(0)
+	green.Println("容器已啟動")
 	return nil
 }
 
 func loadDefaultEnvs(cfg *Config) error {
-	data, err := os.ReadFile(exampleFile)
+	env, err := envfile.ReadFile(exampleFile)
 	if err != nil {
 		return fmt.Errorf("讀取 %s 失敗: %w", exampleFile, err)
 	}
-
-	lines := strings.Split(string(data), "\n")
-	for _, line := range lines {
-		trimmed := strings.TrimSpace(line)
-		if strings.HasPrefix(trimmed, "#") || trimmed == "" {
-			continue
-		}
-
-		parts := strings.SplitN(trimmed, "=", 2)
-		if len(parts) == 2 {
-			key := strings.TrimSpace(parts[0])
-			val := strings.TrimSpace(parts[1])
-			cfg.envVars[key] = val
-		}
+	for key, val := range env {
+		cfg.envVars[key] = val
 	}
 
 	return nil
@@ -539,6 +732,10 @@ func askLanguage(cfg *Config) error {
 		Message: "What's your language? / 請選擇語言（上下鍵選取，或按下數字鍵後 enter）：",
 		Options: []string{"1. English", "2. Taiwan Traditional Chinese 台灣繁體中文"},
 	}
+	// 重新設定時預選目前的語言
+	if cfg.Language == "zh-hant" {
+		prompt.Default = prompt.Options[1]
+	}
 
 	var choice string
 	if err := survey.AskOne(prompt, &choice); err != nil {
@@ -561,13 +758,33 @@ func askDomainAndSSL(cfg *Config) error {
 		sslPrompt = "您是否有網域並希望自動設定 SSL？"
 	}
 
+	// 重新設定時以目前的設定作為預設值
+	currentPort := cfg.Port
+
 	var useSSL bool
 	prompt := &survey.Confirm{
 		Message: sslPrompt,
-		Default: false,
+		Default: cfg.UseSSL,
 	}
-	if err := survey.AskOne(prompt, &useSSL); err != nil {
-		return err
+	for {
+		if err := survey.AskOne(prompt, &useSSL); err != nil {
+			return err
+		}
+		if !useSSL {
+			break
+		}
+		// Caddy 固定使用 80 與 443 埠
+		err := checkSitePorts(&Config{UseSSL: true})
+		if err == nil {
+			break
+		}
+		printPortError(cfg, err)
+		if cfg.Language == "zh-hant" {
+			yellow.Println("SSL 需要 80 與 443 埠，請先停止佔用的程式，或選擇不啟用 SSL。")
+		} else {
+			yellow.Println("SSL needs ports 80 and 443. Stop the program using them, or continue without SSL.")
+		}
+		prompt.Default = false
 	}
 	cfg.UseSSL = useSSL
 
@@ -583,17 +800,37 @@ func askDomainAndSSL(cfg *Config) error {
 		// 域名
 		domainInput := &survey.Input{
 			Message: domainPrompt,
+			Default: cfg.Domain,
 		}
-		if err := survey.AskOne(domainInput, &cfg.Domain, survey.WithValidator(survey.Required)); err != nil {
+		domainValidator := survey.WithValidator(func(ans interface{}) error {
+			domain, err := normalizeDomain(ans.(string))
+			if err != nil {
+				return err
+			}
+			return checkCertificateDomain(domain)
+		})
+		if err := survey.AskOne(domainInput, &cfg.Domain, domainValidator); err != nil {
 			return err
 		}
+		cfg.Domain = askedDomain(cfg, cfg.Domain)
 
-		// Email
+		// Email，可留空
 		emailInput := &survey.Input{
 			Message: emailPrompt,
+			Default: cfg.Email,
 		}
-		if err := survey.AskOne(emailInput, &cfg.Email); err != nil {
+		emailValidator := survey.WithValidator(func(ans interface{}) error {
+			if strings.TrimSpace(ans.(string)) == "" {
+				return nil
+			}
+			_, err := normalizeEmail(ans.(string))
 			return err
+		})
+		if err := survey.AskOne(emailInput, &cfg.Email, emailValidator); err != nil {
+			return err
+		}
+		if cfg.Email = strings.TrimSpace(cfg.Email); cfg.Email != "" {
+			cfg.Email, _ = normalizeEmail(cfg.Email)
 		}
 	} else {
 		// 非 SSL 路徑
@@ -604,30 +841,93 @@ func askDomainAndSSL(cfg *Config) error {
 
 		domainInput := &survey.Input{
 			Message: domainPrompt,
+			Default: cfg.Domain,
+		}
+		domainValidator := survey.WithValidator(func(ans interface{}) error {
+			if strings.TrimSpace(ans.(string)) == "" {
+				return nil
+			}
+			_, err := normalizeDomain(ans.(string))
+			return err
+		})
+		if err := survey.AskOne(domainInput, &cfg.Domain, domainValidator); err != nil {
+			return err
 		}
-		if err := survey.AskOne(domainInput, &cfg.Domain); err != nil {
+		if strings.TrimSpace(cfg.Domain) != "" {
+			cfg.Domain = askedDomain(cfg, cfg.Domain)
+		} else {
+			cfg.Domain = ""
+		}
+
+		if err := askHTTPBind(cfg); err != nil {
 			return err
 		}
 
-		if cfg.Domain == "" {
-			portPrompt := "Please enter Port (default 8080):"
-			if cfg.Language == "zh-hant" {
-				portPrompt = "請輸入 Port (預設 8080)："
-			}
+		// 有網域時 nginx 同樣在 HTTP_PORT 提供網站，一律詢問並檢查實際寫入的埠
+		portPrompt := "Please enter Port (default 8080):"
+		if cfg.Language == "zh-hant" {
+			portPrompt = "請輸入 Port (預設 8080)："
+		}
 
-			portInput := &survey.Input{
-				Message: portPrompt,
-				Default: "8080",
+		portInput := &survey.Input{
+			Message: portPrompt,
+			Default: "8080",
+		}
+		if currentPort != "" {
+			portInput.Default = currentPort
+		}
+		validator := survey.WithValidator(func(ans interface{}) error {
+			return validPort(ans.(string))
+		})
+		for {
+			if err := survey.AskOne(portInput, &cfg.Port, validator); err != nil {
+				return err
 			}
-			if err := survey.AskOne(portInput, &cfg.Port); err != nil {
+			err := checkSitePorts(cfg)
+			if err == nil {
+				break
+			}
+			var busy *portInUseError
+			if !errors.As(err, &busy) {
 				return err
 			}
+			printPortError(cfg, err)
+			if busy.next != "" {
+				portInput.Default = busy.next
+			}
 		}
 	}
 
 	return nil
 }
 
+// askedDomain 回傳已通過 normalizeDomain 檢查的網域的 ASCII 形式，國際化網域轉為 punycode 時提示使用者
+func askedDomain(cfg *Config, domain string) string {
+	ascii, _ := normalizeDomain(domain)
+	if ascii != strings.ToLower(strings.TrimSpace(domain)) {
+		if cfg.Language == "zh-hant" {
+			fmt.Printf("網域將以 %s 設定\n", ascii)
+		} else {
+			fmt.Printf("The domain will be configured as %s\n", ascii)
+		}
+	}
+	return ascii
+}
+
+// printPortError 依安裝語言顯示埠無法使用的原因
+func printPortError(cfg *Config, err error) {
+	var busy *portInUseError
+	switch {
+	case !errors.As(err, &busy):
+		red.Printf("✗ %v\n", err)
+	case cfg.Language == "zh-hant":
+		red.Printf("✗ %v\n", busy)
+		fmt.Println(busy.hint())
+	default:
+		red.Printf("✗ %s\n", busy.english())
+	}
+}
+
 func askMySQL(cfg *Config) error {
 	modifyPrompt := "Modify MySQL parameters?"
 	if cfg.Language == "zh-hant" {
@@ -795,56 +1095,33 @@ func randInt(max int) int {
 	return int(n.Int64())
 }
 
+// writeEnvFile 以覆蓋前的 .env 為基礎寫入設定，沒有時使用 example.env，
+// 保留原有的註解、順序與管理員自行加入的變數，新的變數依名稱排序加在最後
 func writeEnvFile(cfg *Config) error {
-	data, err := os.ReadFile(exampleFile)
-	if err != nil {
-		// 如果無法讀取範例檔，直接寫入
-		var lines []string
-		for key, val := range cfg.envVars {
-			lines = append(lines, fmt.Sprintf("%s=\"%s\"", key, val))
+	f := cfg.baseEnv
+	if f == nil {
+		var err error
+		if f, err = envfile.Load(exampleFile); err != nil {
+			// 如果無法讀取範例檔，直接寫入
+			f = envfile.New()
 		}
-		content := strings.Join(lines, "\n") + "\n"
-		return os.WriteFile(targetFile, []byte(content), 0644)
 	}
 
-	// 基於範例檔案更新
-	lines := strings.Split(string(data), "\n")
-	var newContent strings.Builder
-	written := make(map[string]bool)
-
-	for _, line := range lines {
-		trimmed := strings.TrimSpace(line)
+	// envVars 為 example.env 的預設值加上此次的設定，一律寫入，空白的值也寫入空白；
+	// 不在其中的變數（例如 BACKUP_TARGET 與管理員自行加入的變數）保留原本的設定
+	f.SetAll(cfg.envVars)
 
-		// 保留註解和空行
-		if strings.HasPrefix(trimmed, "#") || trimmed == "" {
-			fmt.Fprintln(&newContent, line)
-			continue
-		}
-
-		// 處理環境變數
-		parts := strings.SplitN(trimmed, "=", 2)
-		if len(parts) == 2 {
-			key := strings.TrimSpace(parts[0])
-
-			if val, ok := cfg.envVars[key]; ok && val != "" {
-				fmt.Fprintf(&newContent, "%s=\"%s\"\n", key, val)
-				written[key] = true
-			} else {
-				fmt.Fprintln(&newContent, line)
-			}
-		} else {
-			fmt.Fprintln(&newContent, line)
-		}
-	}
+	return f.WriteFile(targetFile, 0644)
+}
 
-	// 添加未寫入的變數
-	for key, val := range cfg.envVars {
-		if !written[key] && val != "" {
-			fmt.Fprintf(&newContent, "%s=\"%s\"\n", key, val)
-		}
+// updateEnvFile 更新 .env 中的指定變數，其餘內容不變，原本沒有的變數依名稱排序加在最後
+func updateEnvFile(updates map[string]string) error {
+	f, err := envfile.Load(targetFile)
+	if err != nil {
+		return err
 	}
-
-	return os.WriteFile(targetFile, []byte(newContent.String()), 0644)
+	f.SetAll(updates)
+	return f.WriteFile(targetFile, 0644)
 }
 
 func updateCaddyfile(cfg *Config) error {
@@ -878,6 +1155,12 @@ func updateCaddyfile(cfg *Config) error {
 		return fmt.Errorf("無法建立 data 目錄: %w", err)
 	}
 
+	// 使用 Let's Encrypt 正式環境以外的 ACME CA
+	if err := installACMECARoot(cfg); err != nil {
+		return err
+	}
+	content = addCaddyGlobalOptions(content, caddyGlobalOptions(cfg))
+
 	// 寫入檔案
 	if err := os.WriteFile(caddyfile, []byte(content), 0644); err != nil {
 		return err
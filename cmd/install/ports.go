@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// portInUseError 表示埠已被其他程式使用
+type portInUseError struct {
+	port string
+	// owner 為佔用的程式，例如 nginx (PID 812)，無法得知時為空
+	owner string
+	// next 為建議改用的埠，無法建議時為空
+	next string
+}
+
+func (e *portInUseError) Error() string {
+	msg := "埠 " + e.port + " 已被使用"
+	if e.owner != "" {
+		msg = "埠 " + e.port + " 已被 " + e.owner + " 使用"
+	}
+	if e.next != "" {
+		msg += "，可改用 " + e.next
+	}
+	return msg
+}
+
+// english 回傳英文的錯誤訊息，供選擇英文的安裝精靈使用
+func (e *portInUseError) english() string {
+	msg := "Port " + e.port + " is already in use"
+	if e.owner != "" {
+		msg += " by " + e.owner
+	}
+	if e.next != "" {
+		msg += "; port " + e.next + " is free"
+	}
+	return msg
+}
+
+// hint 回傳查看或釋放埠的方式
+func (e *portInUseError) hint() string {
+	if e.owner != "" {
+		return "請停止 " + e.owner
+	}
+	return "請以 sudo ss -ltnp 'sport = :" + e.port + "' 查看佔用的程式"
+}
+
+// validPort 確認埠是 1 到 65535 之間的數字
+func validPort(port string) error {
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("埠必須是 1 到 65535 之間的數字：%q", port)
+	}
+	return nil
+}
+
+// checkPort 嘗試在 bind 位址監聽 port，確認可以發布網站；由此網站的容器佔用時視為可用
+func checkPort(bind, port string) error {
+	if err := validPort(port); err != nil {
+		return err
+	}
+	if err := validBind(bind); err != nil {
+		return err
+	}
+	if bind == "" {
+		bind = "0.0.0.0"
+	}
+	ln, err := net.Listen("tcp", net.JoinHostPort(bind, port))
+	if err == nil {
+		ln.Close()
+		return nil
+	}
+
+	switch {
+	case errors.Is(err, syscall.EADDRNOTAVAIL):
+		return fmt.Errorf("HTTP_BIND %s 不是本機的位址", bind)
+	case errors.Is(err, syscall.EACCES):
+		// 非 root 無法監聽 1024 以下的埠，但 Docker 可以；改以連線判斷是否有服務
+		host := "127.0.0.1"
+		if bind != "0.0.0.0" && bind != "::" {
+			host = bind
+		}
+		conn, dialErr := net.DialTimeout("tcp", net.JoinHostPort(host, port), 2*time.Second)
+		if dialErr != nil {
+			return nil
+		}
+		conn.Close()
+	}
+
+	container := portContainer(port)
+	if strings.HasPrefix(container, "neticrm-") {
+		return nil
+	}
+	n, _ := strconv.Atoi(port)
+	owner := portProcess(n)
+	if container != "" {
+		owner = "容器 " + container
+	}
+	return &portInUseError{port: port, owner: owner}
+}
+
+// portContainer 回傳發布 port 的 Docker 容器名稱
+func portContainer(port string) string {
+	out, err := exec.Command("docker", "ps", "--filter", "publish="+port, "--format", "{{.Names}}").Output()
+	if err != nil {
+		return ""
+	}
+	name, _, _ := strings.Cut(strings.TrimSpace(string(out)), "\n")
+	return name
+}
+
+// nextFreePort 回傳 port 之後第一個可以使用的埠，找不到時回傳空字串
+func nextFreePort(bind, port string) string {
+	n, err := strconv.Atoi(port)
+	if err != nil {
+		return ""
+	}
+	for p := n + 1; p <= min(n+100, 65535); p++ {
+		if checkPort(bind, strconv.Itoa(p)) == nil {
+			return strconv.Itoa(p)
+		}
+	}
+	return ""
+}
+
+// checkSitePorts 確認網站需要的埠都可以使用：SSL 模式由 Caddy 使用 80 與 443，否則由 nginx 使用 HTTPBind 上的 HTTP_PORT
+func checkSitePorts(cfg *Config) error {
+	if cfg.UseSSL {
+		for _, port := range []string{"80", "443"} {
+			if err := checkPort("", port); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if cfg.Port == "" {
+		return nil
+	}
+	bind := cfg.HTTPBind
+	err := checkPort(bind, cfg.Port)
+	var busy *portInUseError
+	if errors.As(err, &busy) {
+		busy.next = nextFreePort(bind, cfg.Port)
+	}
+	return err
+}
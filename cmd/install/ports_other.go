@@ -0,0 +1,8 @@
+//go:build !linux
+
+package main
+
+// portProcess 只在 Linux 由 /proc 查詢佔用埠的程式
+func portProcess(port int) string {
+	return ""
+}
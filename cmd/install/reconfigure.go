@@ -0,0 +1,457 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/AlecAivazis/survey/v2"
+	"github.com/netivism/neticrm-selfhost/internal/envfile"
+)
+
+// envServices 為 .env 變數變更時需要重新建立的 compose 服務
+var envServices = map[string][]string{
+	"LANGUAGE":  {"php-fpm"},
+	"DOMAIN":    {"php-fpm"},
+	"HTTP_PORT": {"nginx"},
+	"HTTP_BIND": {"nginx"},
+}
+
+// reconfigurePlan 記錄重新設定要做的變更
+type reconfigurePlan struct {
+	// env 為要更新的 .env 變數，old 為原本的值
+	env map[string]string
+	old map[string]string
+	// caddy 為 true 時重新產生 Caddyfile
+	caddy          bool
+	sslFrom, sslTo bool
+	services       []string
+	// urls 為網站網址變更時要更新的網站設定檔與 CiviCRM 設定，網站尚未安裝時為 nil
+	urls *siteURLChange
+}
+
+func (p *reconfigurePlan) empty() bool {
+	return len(p.env) == 0 && !p.caddy && p.sslFrom == p.sslTo
+}
+
+func runReconfigure(args []string) error {
+	fs := newFlagSet("reconfigure")
+	language := fs.String("language", "", "語言：en 或 zh-hant")
+	domain := fs.String("domain", "", "網站網域")
+	email := fs.String("email", "", "Let's Encrypt 憑證使用的電子郵件")
+	port := fs.String("port", "", "未使用 SSL 時的 HTTP 埠")
+	acmeCA := fs.String("acme-ca", "", "Caddy 申請憑證的 ACME CA：production、staging 或 ACME 目錄網址")
+	acmeCARoot := fs.String("acme-ca-root", "", "自訂 ACME CA 的根憑證 PEM 檔")
+	httpBind := fs.String("http-bind", "", "未使用 SSL 時 nginx 監聽的位址，例如 127.0.0.1 或 ::1（空白為所有網路介面）")
+	var ssl *bool
+	fs.Var(optionalBool{answerField{name: "ssl", b: &ssl}}, "ssl", "使用 Caddy 自動設定 SSL（--ssl=false 停用）")
+	yes := fs.Bool("yes", false, "不詢問確認，直接套用變更")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := noArgs(fs); err != nil {
+		return err
+	}
+	if err := requireInstalled(); err != nil {
+		return err
+	}
+
+	before, err := loadCurrentConfig()
+	if err != nil {
+		return err
+	}
+	after := *before
+
+	// 有指定任何設定旗標時只修改這些設定，不詢問
+	flagged := false
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "language":
+			after.Language = *language
+		case "domain":
+			after.Domain = *domain
+		case "email":
+			after.Email = *email
+		case "port":
+			after.Port = *port
+		case "http-bind":
+			after.HTTPBind = normalizeBind(*httpBind)
+		case "acme-ca":
+			after.ACMECA = *acmeCA
+		case "acme-ca-root":
+			after.ACMECARoot = *acmeCARoot
+		case "ssl":
+			after.UseSSL = *ssl
+		default:
+			return
+		}
+		flagged = true
+	})
+
+	if flagged {
+		if err := validateReconfigure(before, &after); err != nil {
+			return usageError{msg: err.Error()}
+		}
+	} else {
+		cyan.Println("目前的設定會作為預設值，直接按 enter 保留原設定。")
+		if err := askLanguage(&after); err != nil {
+			return err
+		}
+		if err := askDomainAndSSL(&after); err != nil {
+			return err
+		}
+		if err := validateReconfigure(before, &after); err != nil {
+			return err
+		}
+	}
+
+	return confirmAndApply(before, &after, *yes)
+}
+
+// confirmAndApply 列出 before 到 after 的變更，確認後套用
+func confirmAndApply(before, after *Config, yes bool) error {
+	// 網站安裝後網域也存在網站設定檔與 CiviCRM 資料庫中，必須一起更新
+	if after.Domain != before.Domain && fileExists(civicrmSettingsFile) {
+		return fmt.Errorf("網站已安裝，請先以 %s change-domain %s 變更網域", programName(), after.Domain)
+	}
+
+	plan := planReconfigure(before, after)
+	if plan.empty() {
+		green.Println("設定沒有變更。")
+		return nil
+	}
+	var err error
+	if plan.urls, err = newSiteURLChange(siteURL(before), siteURL(after)); err != nil {
+		return err
+	}
+	if plan.urls != nil && !siteRunning() {
+		return fmt.Errorf("網站網址將變更，但網站未啟動，無法更新 CiviCRM 資料庫中的網址，請先執行 %s start", programName())
+	}
+	if err := checkSitePorts(after); err != nil {
+		var busy *portInUseError
+		if errors.As(err, &busy) {
+			return fmt.Errorf("%v；%s", busy, busy.hint())
+		}
+		return err
+	}
+	// Caddy 會為新的網域或向新的 CA 申請憑證
+	if after.UseSSL && (!before.UseSSL || before.Domain != after.Domain || before.ACMECA != after.ACMECA) {
+		ok, err := confirmDomainDNS(after.Domain, after.Language, yes)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			fmt.Println("未變更任何設定。")
+			return nil
+		}
+	}
+	printReconfigurePlan(plan, after)
+
+	if !yes {
+		confirm := false
+		prompt := &survey.Confirm{
+			Message: "確定要套用以上變更嗎？",
+			Default: false,
+		}
+		if err := survey.AskOne(prompt, &confirm); err != nil {
+			return err
+		}
+		if !confirm {
+			fmt.Println("未變更任何設定。")
+			return nil
+		}
+	}
+
+	return applyReconfigure(plan, after)
+}
+
+// loadCurrentConfig 由現有的 .env 與 Caddyfile 建立 Config
+func loadCurrentConfig() (*Config, error) {
+	env, err := envfile.Load(targetFile)
+	if err != nil {
+		return nil, fmt.Errorf("讀取 %s 失敗: %w", targetFile, err)
+	}
+	get := func(key string) string {
+		v, _ := env.Get(key)
+		return v
+	}
+
+	cfg := &Config{
+		Language:           get("LANGUAGE"),
+		Domain:             get("DOMAIN"),
+		Port:               get("HTTP_PORT"),
+		HTTPBind:           normalizeBind(get("HTTP_BIND")),
+		MySQLRootPassword:  get("MYSQL_ROOT_PASSWORD"),
+		MySQLDatabase:      get("MYSQL_DATABASE"),
+		MySQLUser:          get("MYSQL_USER"),
+		MySQLPassword:      get("MYSQL_PASSWORD"),
+		AdminLoginUser:     get("ADMIN_LOGIN_USER"),
+		AdminLoginPassword: get("ADMIN_LOGIN_PASSWORD"),
+		NeticrmVersion:     get("NETICRM_VERSION"),
+		BackupRecipients:   get("BACKUP_AGE_RECIPIENTS"),
+		envVars:            env.Map(),
+		baseEnv:            env,
+	}
+	if cfg.Language != "zh-hant" {
+		cfg.Language = "en"
+	}
+	if cfg.Domain == "localhost" {
+		cfg.Domain = ""
+	}
+	cfg.UseSSL = envUsesSSL(env)
+	if cfg.UseSSL && fileExists(caddyfile) {
+		if d := getDomainFromCaddyfile(); d != "" {
+			cfg.Domain = d
+		}
+		cfg.Email = getEmailFromCaddyfile()
+		cfg.ACMECA, cfg.ACMECARoot = getACMEFromCaddyfile()
+	}
+	return cfg, nil
+}
+
+// validateReconfigure 檢查修改後的設定 cfg，before 為目前的設定
+func validateReconfigure(before, cfg *Config) error {
+	switch cfg.Language {
+	case "en", "zh-hant":
+	default:
+		return fmt.Errorf("不支援的語言 %q，請使用 en 或 zh-hant", cfg.Language)
+	}
+	// 只檢查有變更的網域與電子郵件，既有的設定維持原樣
+	if cfg.Domain != before.Domain && cfg.Domain != "" {
+		domain, err := normalizeDomain(cfg.Domain)
+		if err != nil {
+			return err
+		}
+		cfg.Domain = domain
+	}
+	if cfg.UseSSL {
+		if cfg.Domain == "" {
+			return fmt.Errorf("啟用 SSL 時必須設定網域")
+		}
+		if !before.UseSSL || cfg.Domain != before.Domain {
+			if err := checkCertificateDomain(cfg.Domain); err != nil {
+				return err
+			}
+		}
+		if cfg.Email != before.Email && cfg.Email != "" {
+			email, err := normalizeEmail(cfg.Email)
+			if err != nil {
+				return err
+			}
+			cfg.Email = email
+		}
+		if err := validateACMECA(before, cfg); err != nil {
+			return err
+		}
+	}
+	if !cfg.UseSSL {
+		if cfg.ACMECA != before.ACMECA || cfg.ACMECARoot != before.ACMECARoot {
+			return fmt.Errorf("ACME CA 只在啟用 SSL 時使用，請加上 --ssl")
+		}
+		if cfg.Port == "" {
+			cfg.Port = "8080"
+		}
+		if err := validPort(cfg.Port); err != nil {
+			return fmt.Errorf("HTTP %w", err)
+		}
+		if err := validBind(cfg.HTTPBind); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// siteEnv 回傳 cfg 對應的 .env 網站設定
+func siteEnv(cfg *Config) map[string]string {
+	env := map[string]string{
+		"LANGUAGE": cfg.Language,
+		"DOMAIN":   cfg.Domain,
+		"SSL_MODE": sslMode(cfg.UseSSL),
+	}
+	if env["DOMAIN"] == "" {
+		env["DOMAIN"] = "localhost"
+	}
+	// 使用 SSL 時由 Caddy 對外，保留原本的 HTTP_PORT 以便之後停用 SSL
+	if !cfg.UseSSL {
+		env["HTTP_PORT"] = cfg.Port
+		env["HTTP_BIND"] = cfg.HTTPBind
+	}
+	return env
+}
+
+// planReconfigure 比較前後設定，只列出確實變更的項目
+func planReconfigure(before, after *Config) *reconfigurePlan {
+	plan := &reconfigurePlan{
+		env:     make(map[string]string),
+		old:     make(map[string]string),
+		sslFrom: before.UseSSL,
+		sslTo:   after.UseSSL,
+	}
+
+	services := make(map[string]bool)
+	for key, val := range siteEnv(after) {
+		old, _ := before.baseEnv.Get(key)
+		if old == val {
+			continue
+		}
+		plan.env[key] = val
+		plan.old[key] = old
+		for _, s := range envServices[key] {
+			services[s] = true
+		}
+	}
+
+	if after.UseSSL && (!before.UseSSL || before.Domain != after.Domain || before.Email != after.Email ||
+		before.ACMECA != after.ACMECA || before.ACMECARoot != after.ACMECARoot) {
+		plan.caddy = true
+		services["caddy"] = true
+	}
+
+	for s := range services {
+		plan.services = append(plan.services, s)
+	}
+	sort.Strings(plan.services)
+	return plan
+}
+
+func printReconfigurePlan(plan *reconfigurePlan, cfg *Config) {
+	fmt.Println()
+	cyan.Println("將進行以下變更：")
+
+	if len(plan.env) > 0 {
+		fmt.Printf("  %s\n", targetFile)
+		keys := make([]string, 0, len(plan.env))
+		for key := range plan.env {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Printf("    %s: %s → %s\n", key, displayValue(plan.old[key]), displayValue(plan.env[key]))
+		}
+	}
+
+	switch {
+	case plan.caddy:
+		email := cfg.Email
+		if email == "" {
+			email = "（未設定）"
+		}
+		fmt.Printf("  %s：重新產生（網域 %s，email %s，CA %s）\n", caddyfile, cfg.Domain, email, acmeCAName(cfg.ACMECA))
+		if cfg.ACMECARoot != "" {
+			fmt.Printf("  %s：ACME CA 根憑證（%s）\n", acmeCARootFile, cfg.ACMECARoot)
+		}
+	case plan.sslFrom && !plan.sslTo:
+		fmt.Printf("  %s：移至備份組（停用 SSL）\n", caddyfile)
+	}
+
+	plan.urls.describe()
+
+	if plan.sslFrom != plan.sslTo {
+		fmt.Printf("  Compose 檔案：%s → %s，所有服務將重新啟動\n", composeFileFor(plan.sslFrom), composeFileFor(plan.sslTo))
+	} else if len(plan.services) > 0 {
+		fmt.Printf("  將重新建立的服務：%s\n", strings.Join(plan.services, ", "))
+	}
+	fmt.Println()
+}
+
+func displayValue(v string) string {
+	if v == "" {
+		return "（空白）"
+	}
+	return v
+}
+
+// composeFileFor 回傳是否使用 SSL 時對應的 compose 檔案
+func composeFileFor(ssl bool) string {
+	if ssl {
+		return sslComposeFile
+	}
+	return defaultComposeFile
+}
+
+// applyReconfigure 先將 .env 與 Caddyfile 備份，再寫入變更並只重新啟動受影響的服務
+func applyReconfigure(plan *reconfigurePlan, cfg *Config) error {
+	beginBackupSet(reasonReconfigure)
+	set, err := currentBackupSet()
+	if err != nil {
+		return err
+	}
+	if _, err := set.copyIn(targetFile); err != nil {
+		return err
+	}
+	if err := plan.urls.backup(set); err != nil {
+		return err
+	}
+
+	if len(plan.env) > 0 {
+		if err := updateEnvFile(plan.env); err != nil {
+			return fmt.Errorf("更新 %s 失敗: %w", targetFile, err)
+		}
+	}
+	switch {
+	case plan.caddy:
+		if err := updateCaddyfile(cfg); err != nil {
+			return fmt.Errorf("更新 Caddyfile 失敗: %w", err)
+		}
+	case plan.sslFrom && !plan.sslTo:
+		if err := backupFile(caddyfile); err != nil {
+			return err
+		}
+	}
+	if err := plan.urls.writeFiles(); err != nil {
+		return err
+	}
+	green.Printf("✅ 設定已更新，原設定備份於 %s\n", set.dir)
+
+	if err := checkDocker(); err != nil || !containerRunning(phpContainer) {
+		yellow.Printf("網站目前未啟動，新設定會在下次執行 %s start 時生效。\n", programName())
+		return nil
+	}
+
+	if plan.sslFrom != plan.sslTo {
+		if err := dockerCompose(composeFileFor(plan.sslFrom), "down"); err != nil {
+			return err
+		}
+		if err := dockerCompose(composeFileFor(plan.sslTo), "up", "-d"); err != nil {
+			return err
+		}
+	} else if len(plan.services) > 0 {
+		// Caddyfile 是單一檔案的掛載，必須重新建立容器才會讀到新檔案
+		composeArgs := append([]string{"up", "-d", "--no-deps", "--force-recreate"}, plan.services...)
+		if err := dockerCompose(composeFileFor(plan.sslTo), composeArgs...); err != nil {
+			return fmt.Errorf("%w，原設定備份於 %s", err, set.dir)
+		}
+	}
+	if err := plan.urls.updateDatabase(); err != nil {
+		return fmt.Errorf("%w，原設定備份於 %s", err, set.dir)
+	}
+
+	if plan.sslFrom != plan.sslTo || len(plan.services) > 0 {
+		if err := smokeTest(cfg, 2*time.Minute); err != nil {
+			if cfg.UseSSL {
+				yellow.Printf("請確認網域的 DNS 已指向此主機，且防火牆開放 80 與 443 埠；Caddy 的紀錄可用 %s logs caddy 檢視，執行 %s ssl disable 可切回 HTTP。\n", programName(), programName())
+			}
+			return fmt.Errorf("%w，原設定備份於 %s", err, set.dir)
+		}
+	}
+	green.Printf("網站網址：%s\n", siteURL(cfg))
+	return nil
+}
+
+// siteURL 回傳網站對外的網址
+func siteURL(cfg *Config) string {
+	if cfg.UseSSL {
+		return "https://" + cfg.Domain
+	}
+	host := cfg.Domain
+	if host == "" {
+		host = "localhost"
+	}
+	if cfg.Port == "" || cfg.Port == "80" {
+		return "http://" + host
+	}
+	return "http://" + host + ":" + cfg.Port
+}
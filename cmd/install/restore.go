@@ -0,0 +1,504 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"github.com/AlecAivazis/survey/v2"
+	"github.com/netivism/neticrm-selfhost/internal/envfile"
+)
+
+func runRestore(args []string) error {
+	fs := newFlagSet("restore")
+	yes := fs.Bool("yes", false, "不詢問確認，直接還原")
+	timeout := fs.Duration("timeout", 10*time.Minute, "等待資料庫與網站就緒的時間上限")
+	identity := fs.String("identity", "", "解密用的 age 私鑰檔（預設使用 BACKUP_AGE_IDENTITY）")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return newUsageError("請指定一個備份封存檔")
+	}
+	archivePath := fs.Arg(0)
+
+	ids, err := archiveIdentities(archivePath, *identity)
+	if err != nil {
+		return err
+	}
+
+	// 先完整驗證封存檔，驗證失敗不會動到現有網站
+	cyan.Printf("驗證備份封存檔 %s ...\n", archivePath)
+	manifest, err := verifyArchive(archivePath, ids)
+	if err != nil {
+		return err
+	}
+	green.Printf("✓ 封存檔驗證通過（建立於 %s，%d 個檔案，資料庫 %d 個資料表）\n",
+		manifest.CreatedAt.Local().Format("2006-01-02 15:04:05"), len(manifest.Files), manifest.Database.Tables)
+	if manifest.NeticrmVersion != "" {
+		fmt.Printf("  netiCRM 版本：%s\n", manifest.NeticrmVersion)
+	}
+
+	if err := checkDocker(); err != nil {
+		return err
+	}
+
+	if !*yes {
+		yellow.Println("⚠️  還原會停止網站，並以備份內容取代目前的資料庫、上傳檔案與設定（現有資料會移入備份組）。")
+		confirm := false
+		prompt := &survey.Confirm{
+			Message: "確定要還原嗎？",
+			Default: false,
+		}
+		if err := survey.AskOne(prompt, &confirm); err != nil {
+			return err
+		}
+		if !confirm {
+			fmt.Println("還原取消。")
+			return nil
+		}
+	}
+
+	// 停止現有服務並將現有資料移入備份組
+	beginBackupSet(reasonRestore)
+	if fileExists(targetFile) {
+		if err := dockerCompose(currentComposeFile(), "down"); err != nil {
+			return err
+		}
+	}
+	moveAside := []string{"data/mariadb_data", siteFilesDir}
+	for _, cf := range siteConfigFiles {
+		moveAside = append(moveAside, cf.path)
+	}
+	for _, p := range moveAside {
+		if fileExists(p) {
+			if err := backupFile(p); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := os.MkdirAll("data", 0755); err != nil {
+		return fmt.Errorf("無法建立 data 目錄: %w", err)
+	}
+	dumpFile, err := os.CreateTemp("data", ".neticrm-restore-*.sql")
+	if err != nil {
+		return fmt.Errorf("無法建立暫存檔: %w", err)
+	}
+	dumpFile.Close()
+	defer os.Remove(dumpFile.Name())
+
+	cyan.Println("解開設定檔與上傳檔案 ...")
+	if err := extractArchive(archivePath, dumpFile.Name(), ids); err != nil {
+		return err
+	}
+
+	env, err := envfile.ReadFile(targetFile)
+	if err != nil {
+		return fmt.Errorf("讀取還原後的 %s 失敗: %w", targetFile, err)
+	}
+	composeFile := currentComposeFile()
+
+	// 先只啟動全新的 MariaDB 容器並匯入資料庫
+	cyan.Println("啟動 MariaDB 並匯入資料庫 ...")
+	if err := dockerCompose(composeFile, "up", "-d", "mariadb"); err != nil {
+		return err
+	}
+	if err := waitForMariaDB(env, *timeout); err != nil {
+		return err
+	}
+	if err := importDatabase(env, dumpFile.Name()); err != nil {
+		return err
+	}
+	green.Println("✓ 資料庫匯入完成")
+
+	cyan.Println("啟動所有服務 ...")
+	if err := dockerCompose(composeFile, "up", "-d"); err != nil {
+		return err
+	}
+
+	if err := waitForDrupal(*timeout); err != nil {
+		return err
+	}
+	if err := dockerExec(phpContainer, "chown", "-R", "www-data:www-data", "/var/www/html/sites/default/files"); err != nil {
+		return fmt.Errorf("設定上傳檔案擁有者失敗: %w", err)
+	}
+	if err := dockerExec(phpContainer, "drush", "--yes", "cr"); err != nil {
+		return fmt.Errorf("drush cr 失敗: %w", err)
+	}
+	if err := dockerExec(phpContainer, "drush", "--yes", "updb"); err != nil {
+		return fmt.Errorf("drush updb 失敗: %w", err)
+	}
+
+	green.Println("✅ 網站還原完成！")
+	return nil
+}
+
+// archiveIdentities 封存檔有加密時取得解密用的私鑰，未加密時回傳 nil
+func archiveIdentities(archivePath, identityFile string) ([]age.Identity, error) {
+	encrypted, err := isEncryptedFile(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("無法開啟封存檔: %w", err)
+	}
+	if !encrypted {
+		return nil, nil
+	}
+
+	var info *backupEncryption
+	if record, _ := readArchiveRecord(archivePath); record != nil && record.Manifest != nil {
+		info = record.Manifest.Encryption
+	}
+	if info != nil {
+		cyan.Printf("封存檔已加密（%s）\n", info.describe())
+	}
+
+	// 目前網站的 .env 可能設定了私鑰檔或密碼，讀不到時只使用命令列與環境變數
+	env, _ := envfile.ReadFile(targetFile)
+	return decryptionIdentities(identityFile, env, info)
+}
+
+// openArchive 開啟 .tar.gz 封存檔，ids 不為 nil 時先以 age 解密
+func openArchive(archivePath string, ids []age.Identity) (*tar.Reader, func(), error) {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("無法開啟封存檔: %w", err)
+	}
+
+	var r io.Reader = f
+	if encrypted, _ := isEncryptedFile(archivePath); encrypted {
+		if ids == nil {
+			f.Close()
+			return nil, nil, errors.New("封存檔已加密，請提供私鑰或密碼")
+		}
+		var info *backupEncryption
+		if record, _ := readArchiveRecord(archivePath); record != nil && record.Manifest != nil {
+			info = record.Manifest.Encryption
+		}
+		if r, err = decrypt(f, ids, info); err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+	}
+
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		f.Close()
+		return nil, nil, fmt.Errorf("封存檔不是有效的 gzip 檔案: %w", err)
+	}
+	return tar.NewReader(gz), func() { gz.Close(); f.Close() }, nil
+}
+
+// verifyArchive 讀取整個封存檔，確認 manifest 格式以及每個檔案的大小與雜湊
+func verifyArchive(archivePath string, ids []age.Identity) (*siteManifest, error) {
+	if err := verifyArchiveRecord(archivePath); err != nil {
+		return nil, err
+	}
+
+	tr, closeArchive, err := openArchive(archivePath, ids)
+	if err != nil {
+		return nil, err
+	}
+	defer closeArchive()
+
+	var manifest *siteManifest
+	actual := make(map[string]manifestFile)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("封存檔已損毀: %w", err)
+		}
+		if err := checkArchiveName(hdr.Name); err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag == tar.TypeSymlink {
+			if err := checkArchiveSymlink(hdr.Name, hdr.Linkname); err != nil {
+				return nil, err
+			}
+		}
+
+		if hdr.Name == archiveManifest {
+			manifest = &siteManifest{}
+			if err := json.NewDecoder(tr).Decode(manifest); err != nil {
+				return nil, fmt.Errorf("無法解析 %s: %w", archiveManifest, err)
+			}
+			continue
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		hash := sha256.New()
+		n, err := io.Copy(hash, tr)
+		if err != nil {
+			return nil, fmt.Errorf("封存檔已損毀（%s）: %w", hdr.Name, err)
+		}
+		actual[hdr.Name] = manifestFile{Path: hdr.Name, Size: n, SHA256: hex.EncodeToString(hash.Sum(nil))}
+	}
+
+	if manifest == nil {
+		return nil, fmt.Errorf("封存檔缺少 %s，不是 netiCRM 網站備份", archiveManifest)
+	}
+	if manifest.Format != archiveFormat {
+		return nil, fmt.Errorf("不支援的封存檔格式 %q", manifest.Format)
+	}
+	if manifest.FormatVersion < 1 || manifest.FormatVersion > archiveFormatVersion {
+		return nil, fmt.Errorf("封存檔格式版本 %d 不受此版安裝程式支援（最高 %d），請更新安裝程式", manifest.FormatVersion, archiveFormatVersion)
+	}
+
+	var problems []string
+	listed := make(map[string]bool)
+	for _, want := range manifest.Files {
+		listed[want.Path] = true
+		got, ok := actual[want.Path]
+		switch {
+		case !ok:
+			problems = append(problems, want.Path+"：缺少檔案")
+		case got.Size != want.Size:
+			problems = append(problems, fmt.Sprintf("%s：大小不符（%d ≠ %d）", want.Path, got.Size, want.Size))
+		case got.SHA256 != want.SHA256:
+			problems = append(problems, want.Path+"：SHA-256 不符")
+		}
+	}
+	for name := range actual {
+		if !listed[name] {
+			problems = append(problems, name+"：未列在 manifest 中")
+		}
+	}
+	if !listed[archiveDatabase] {
+		problems = append(problems, archiveDatabase+"：缺少資料庫備份")
+	}
+	if !listed[archiveConfigDir+"/.env"] {
+		problems = append(problems, archiveConfigDir+"/.env：缺少設定檔")
+	}
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("封存檔驗證失敗，拒絕還原：\n  - %s", strings.Join(problems, "\n  - "))
+	}
+
+	return manifest, nil
+}
+
+// archiveRecordPath 回傳封存檔旁說明檔的路徑，例如 neticrm-site-*.tar.gz.age => neticrm-site-*.json
+func archiveRecordPath(archivePath string) string {
+	base := strings.TrimSuffix(strings.TrimSuffix(archivePath, encryptedSuffix), ".tar.gz")
+	if base == archivePath {
+		return ""
+	}
+	return base + ".json"
+}
+
+// readArchiveRecord 讀取封存檔旁的說明檔，沒有或無法解析時回傳 nil
+func readArchiveRecord(archivePath string) (*siteBackupRecord, error) {
+	recordPath := archiveRecordPath(archivePath)
+	if recordPath == "" || !fileExists(recordPath) {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(recordPath)
+	if err != nil {
+		return nil, err
+	}
+	record := &siteBackupRecord{}
+	if err := json.Unmarshal(data, record); err != nil || record.SHA256 == "" {
+		return nil, nil
+	}
+	return record, nil
+}
+
+// verifyArchiveRecord 若封存檔旁有說明檔，比對整個封存檔的 SHA-256
+func verifyArchiveRecord(archivePath string) error {
+	record, err := readArchiveRecord(archivePath)
+	if err != nil || record == nil {
+		return err
+	}
+	recordPath := archiveRecordPath(archivePath)
+
+	sum, _, err := sha256File(archivePath)
+	if err != nil {
+		return err
+	}
+	if sum != record.SHA256 {
+		return fmt.Errorf("封存檔 SHA-256 與 %s 不符，拒絕還原", recordPath)
+	}
+	return nil
+}
+
+// checkArchiveName 拒絕絕對路徑或跳出目錄的項目
+func checkArchiveName(name string) error {
+	clean := path.Clean(name)
+	if path.IsAbs(name) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return fmt.Errorf("封存檔含有不安全的路徑 %q，拒絕還原", name)
+	}
+	return nil
+}
+
+// checkArchiveSymlink 只允許 files/ 下指向 files/ 內的相對符號連結，避免還原時經由連結寫到網站目錄以外
+func checkArchiveSymlink(name, linkname string) error {
+	name = path.Clean(name)
+	if !strings.HasPrefix(name, archiveFilesDir+"/") {
+		return fmt.Errorf("封存檔項目 %s 是符號連結，拒絕還原", name)
+	}
+	target := path.Join(path.Dir(name), linkname)
+	if path.IsAbs(linkname) || filepath.IsAbs(linkname) ||
+		(target != archiveFilesDir && !strings.HasPrefix(target, archiveFilesDir+"/")) {
+		return fmt.Errorf("封存檔項目 %s 是指向 %s 的符號連結，超出 %s/，拒絕還原", name, linkname, archiveFilesDir)
+	}
+	return nil
+}
+
+// extractArchive 解開設定檔與上傳檔案，資料庫寫入 dumpPath
+func extractArchive(archivePath, dumpPath string, ids []age.Identity) error {
+	tr, closeArchive, err := openArchive(archivePath, ids)
+	if err != nil {
+		return err
+	}
+	defer closeArchive()
+
+	configPaths := make(map[string]string)
+	for _, cf := range siteConfigFiles {
+		configPaths[archiveConfigDir+"/"+cf.name] = cf.path
+	}
+
+	symlinks := make(map[string]bool)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("封存檔已損毀: %w", err)
+		}
+
+		name := strings.TrimSuffix(path.Clean(hdr.Name), "/")
+		var dest string
+		switch {
+		case name == archiveDatabase:
+			dest = dumpPath
+		case configPaths[name] != "":
+			dest = configPaths[name]
+		case name == archiveFilesDir || strings.HasPrefix(name, archiveFilesDir+"/"):
+			// 不可經由封存檔內的符號連結寫到其他位置
+			for dir := path.Dir(name); dir != "." && dir != "/"; dir = path.Dir(dir) {
+				if symlinks[dir] {
+					return fmt.Errorf("封存檔項目 %s 位於符號連結之下，拒絕還原", hdr.Name)
+				}
+			}
+			dest = filepath.Join(siteFilesDir, filepath.FromSlash(strings.TrimPrefix(name, archiveFilesDir)))
+		default:
+			continue
+		}
+
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return err
+		}
+		// 寫入檔案會跟隨符號連結，不可覆寫已存在的連結
+		if fi, err := os.Lstat(dest); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("%s 是符號連結，拒絕以封存檔項目 %s 覆寫", dest, hdr.Name)
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(dest, os.FileMode(hdr.Mode).Perm()); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if err := checkArchiveSymlink(name, hdr.Linkname); err != nil {
+				return err
+			}
+			symlinks[name] = true
+			if err := os.Symlink(hdr.Linkname, dest); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := writeArchiveFile(tr, dest, os.FileMode(hdr.Mode).Perm()); err != nil {
+				return fmt.Errorf("寫入 %s 失敗: %w", dest, err)
+			}
+			os.Chtimes(dest, hdr.ModTime, hdr.ModTime)
+		}
+	}
+}
+
+func writeArchiveFile(r io.Reader, dest string, mode os.FileMode) error {
+	f, err := os.OpenFile(dest, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// waitForMariaDB 等待全新的 MariaDB 完成初始化並可由 TCP 連線
+func waitForMariaDB(env map[string]string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		// 初始化期間的暫時伺服器不開放網路連線，因此以 TCP 確認是否真正就緒
+		cmd := exec.Command("docker", "exec", "-e", "MYSQL_PWD", mariadbContainer,
+			"mariadb", "--user=root", "--host=127.0.0.1", "-e", "SELECT 1")
+		cmd.Env = append(os.Environ(), "MYSQL_PWD="+env["MYSQL_ROOT_PASSWORD"])
+		if cmd.Run() == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("等待 MariaDB 就緒逾時（%s）", timeout)
+		}
+		time.Sleep(3 * time.Second)
+	}
+}
+
+// importDatabase 將 SQL 檔匯入 MariaDB
+func importDatabase(env map[string]string, dumpPath string) error {
+	f, err := os.Open(dumpPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	database := envOrDefault(env, "MYSQL_DATABASE", "neticrmdb")
+	create := fmt.Sprintf("CREATE DATABASE IF NOT EXISTS `%s` CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci;\nUSE `%s`;\n",
+		database, database)
+
+	cmd := exec.Command("docker", "exec", "-i", "-e", "MYSQL_PWD", mariadbContainer,
+		"mariadb", "--user=root", "--default-character-set=utf8mb4")
+	cmd.Env = append(os.Environ(), "MYSQL_PWD="+env["MYSQL_ROOT_PASSWORD"])
+	cmd.Stdin = io.MultiReader(strings.NewReader(create), f)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("匯入資料庫失敗: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// waitForDrupal 等待 neticrm-php 容器內的 Drupal 可以正常啟動
+func waitForDrupal(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		out, err := exec.Command("docker", "exec", phpContainer, "drush", "status", "--field=bootstrap").Output()
+		if err == nil && strings.Contains(string(out), "Successful") {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return errors.New("等待 Drupal 就緒逾時，請以 logs 命令檢查 php-fpm 容器")
+		}
+		time.Sleep(5 * time.Second)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// archiveEntry 為測試用封存檔中的一個項目，linkname 不為空時建立符號連結
+type archiveEntry struct {
+	name     string
+	linkname string
+	body     string
+}
+
+// writeTestArchive 將 entries 依序寫入 .tar.gz 並回傳檔案路徑
+func writeTestArchive(t *testing.T, entries []archiveEntry) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "site.tar.gz")
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0644, Typeflag: tar.TypeReg, Size: int64(len(e.body))}
+		if e.linkname != "" {
+			hdr = &tar.Header{Name: e.name, Mode: 0777, Typeflag: tar.TypeSymlink, Linkname: e.linkname}
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestExtractArchiveSymlinks(t *testing.T) {
+	// 網站目錄之外的目錄，不可被封存檔寫入
+	outside := t.TempDir()
+
+	tests := []struct {
+		name    string
+		entries []archiveEntry
+		wantErr bool
+	}{
+		{
+			name: "relative link inside files",
+			entries: []archiveEntry{
+				{name: "files/a/logo.png", body: "png"},
+				{name: "files/logo.png", linkname: "a/logo.png"},
+			},
+		},
+		{
+			name: "absolute link then a file with the same name",
+			entries: []archiveEntry{
+				{name: "files/x", linkname: filepath.Join(outside, "x")},
+				{name: "files/x", body: "pwned"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "relative link leaving files",
+			entries: []archiveEntry{
+				{name: "files/x", linkname: "../../../../../x"},
+				{name: "files/x", body: "pwned"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "link to the files directory itself",
+			entries: []archiveEntry{
+				{name: "files/a/up", linkname: ".."},
+			},
+		},
+		{
+			name: "file overwriting a link inside files",
+			entries: []archiveEntry{
+				{name: "files/a/logo.png", body: "png"},
+				{name: "files/logo.png", linkname: "a/logo.png"},
+				{name: "files/logo.png", body: "replaced"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "entry under a linked directory",
+			entries: []archiveEntry{
+				{name: "files/a/.keep", body: ""},
+				{name: "files/b", linkname: "a"},
+				{name: "files/b/x", body: "x"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "link as a config file",
+			entries: []archiveEntry{
+				{name: "config/.env", linkname: "../files/x"},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			archive := writeTestArchive(t, tt.entries)
+			dir := t.TempDir()
+			t.Chdir(dir)
+			err := extractArchive(archive, filepath.Join(dir, "database.sql"), nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("extractArchive() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if _, err := os.Lstat(filepath.Join(outside, "x")); !os.IsNotExist(err) {
+				t.Errorf("extractArchive() wrote outside the site directory")
+			}
+		})
+	}
+}
+
+func TestCheckArchiveSymlink(t *testing.T) {
+	tests := []struct {
+		name     string
+		linkname string
+		wantErr  bool
+	}{
+		{"files/a", "b", false},
+		{"files/a/b", "../c", false},
+		{"files/a/b", "..", false},
+		{"files/a", "..", true},
+		{"files/a", "../config/.env", true},
+		{"files/a", "/etc/cron.d", true},
+		{"files/a/b", "../../../etc", true},
+		{"config/.env", "x", true},
+		{"database.sql", "files/x", true},
+	}
+	for _, tt := range tests {
+		err := checkArchiveSymlink(tt.name, tt.linkname)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("checkArchiveSymlink(%q, %q) error = %v, wantErr %v", tt.name, tt.linkname, err, tt.wantErr)
+		}
+	}
+}
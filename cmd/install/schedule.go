@@ -0,0 +1,515 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/netivism/neticrm-selfhost/internal/envfile"
+)
+
+const (
+	scheduleUnit      = "neticrm-backup"
+	systemdUnitDir    = "/etc/systemd/system"
+	scheduleCronTag   = "# neticrm-backup:"
+	scheduleLogFile   = "schedule.log"
+	backupStatusFile  = "last-run.json"
+	statusSuccess     = "success"
+	statusFailed      = "failed"
+	defaultBackupTime = "03:00"
+)
+
+// backupRunStatus 是每次執行 backup 後寫入備份目錄的 last-run.json，供監控程式讀取
+type backupRunStatus struct {
+	Status     string    `json:"status"`
+	Error      string    `json:"error,omitempty"`
+	Reason     string    `json:"reason"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	SetID      string    `json:"set_id,omitempty"`
+	Target     string    `json:"target,omitempty"`
+	Uploaded   bool      `json:"uploaded"`
+	Pruned     []string  `json:"pruned,omitempty"`
+}
+
+// recordBackupRun 寫入執行結果，先寫入暫存檔再改名，監控程式不會讀到寫一半的檔案
+func recordBackupRun(root string, run *backupRunStatus, err error) {
+	run.FinishedAt = time.Now()
+	run.Status = statusSuccess
+	if err != nil {
+		run.Status = statusFailed
+		run.Error = err.Error()
+	}
+
+	path := filepath.Join(root, backupStatusFile)
+	werr := os.MkdirAll(root, 0700)
+	if werr == nil {
+		werr = writeJSONFile(path+".tmp", run)
+	}
+	if werr == nil {
+		werr = os.Rename(path+".tmp", path)
+	}
+	if werr != nil {
+		yellow.Printf("⚠️  無法寫入備份狀態檔 %s: %v\n", path, werr)
+	}
+}
+
+func readBackupRunStatus(root string) (*backupRunStatus, error) {
+	data, err := os.ReadFile(filepath.Join(root, backupStatusFile))
+	if err != nil {
+		return nil, err
+	}
+	run := &backupRunStatus{}
+	if err := json.Unmarshal(data, run); err != nil {
+		return nil, fmt.Errorf("無法解析 %s: %w", backupStatusFile, err)
+	}
+	return run, nil
+}
+
+// backupSchedule 描述一個每日排程備份
+type backupSchedule struct {
+	hour, minute int
+	workDir      string
+	args         []string
+}
+
+func runBackupSchedule(args []string) error {
+	fs := newFlagSet("backup schedule")
+	at := fs.String("at", defaultBackupTime, "每天執行備份的時間（HH:MM，主機時區）")
+	root := fs.String("dir", backupDir, "備份目錄")
+	target := fs.String("target", "", "上傳的備份目的地（預設使用 .env 的 BACKUP_TARGET）")
+	dbOnly := fs.Bool("db-only", false, "只備份資料庫")
+	allowUnencrypted := fs.Bool("allow-unencrypted", false, "未設定加密時仍上傳備份")
+	var policy retentionPolicy
+	fs.IntVar(&policy.keepLast, "keep-last", 0, "保留最新的 N 個排程備份組")
+	fs.IntVar(&policy.keepDaily, "keep-daily", 7, "保留最近 N 天每天一個排程備份組")
+	fs.IntVar(&policy.keepWeekly, "keep-weekly", 4, "保留最近 N 週每週一個排程備份組")
+	useCron := fs.Bool("cron", false, "使用 crontab，即使主機有 systemd")
+	printOnly := fs.Bool("print", false, "只顯示產生的設定，不安裝")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := noArgs(fs); err != nil {
+		return err
+	}
+	if policy.keepLast < 0 || policy.keepDaily < 0 || policy.keepWeekly < 0 {
+		return newUsageError("保留數量不可為負數")
+	}
+	if policy.keepLast+policy.keepDaily+policy.keepWeekly == 0 {
+		return newUsageError("請至少保留一個備份組，避免排程備份無限制累積或全部被刪除")
+	}
+	t, err := time.Parse("15:04", *at)
+	if err != nil {
+		return newUsageError("--at 必須是 HH:MM 格式：%q", *at)
+	}
+	if err := requireInstalled(); err != nil {
+		return err
+	}
+
+	// 排程執行時 backup 也會再檢查一次，這裡先避免安裝一定會失敗的排程
+	env, err := envfile.ReadFile(targetFile)
+	if err != nil {
+		return fmt.Errorf("讀取 %s 失敗: %w", targetFile, err)
+	}
+	if spec := backupTargetSpec(*target, env); spec != "" && !*allowUnencrypted {
+		enc, err := newBackupEncryptor(env)
+		if err != nil {
+			return err
+		}
+		if enc == nil {
+			return unencryptedUploadError(spec)
+		}
+	}
+
+	workDir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return err
+	}
+
+	s := &backupSchedule{
+		hour:    t.Hour(),
+		minute:  t.Minute(),
+		workDir: workDir,
+		args:    []string{exe, "backup", "--reason", reasonScheduled},
+	}
+	if *root != backupDir {
+		s.args = append(s.args, "--dir", *root)
+	}
+	if *target != "" {
+		s.args = append(s.args, "--target", *target)
+	}
+	if *dbOnly {
+		s.args = append(s.args, "--db-only")
+	}
+	if *allowUnencrypted {
+		s.args = append(s.args, "--allow-unencrypted")
+	}
+	for _, keep := range []struct {
+		flag string
+		n    int
+	}{{"--keep-last", policy.keepLast}, {"--keep-daily", policy.keepDaily}, {"--keep-weekly", policy.keepWeekly}} {
+		if keep.n > 0 {
+			s.args = append(s.args, keep.flag, strconv.Itoa(keep.n))
+		}
+	}
+
+	withSystemd := !*useCron && systemdAvailable()
+	if !*useCron && !withSystemd && !*printOnly {
+		yellow.Println("⚠️  主機沒有 systemd 或目前不是 root，改用 crontab")
+	}
+
+	if *printOnly {
+		if withSystemd {
+			fmt.Printf("# %s\n%s\n# %s\n%s", s.unitPath(".service"), s.serviceUnit(), s.unitPath(".timer"), s.timerUnit())
+		} else {
+			fmt.Println(s.cronLine(*root))
+		}
+		return nil
+	}
+
+	if withSystemd {
+		if err := s.installSystemd(); err != nil {
+			return err
+		}
+		// 改用 systemd 後移除同一目錄舊的 crontab 項目
+		if err := removeCronEntry(workDir); err != nil {
+			yellow.Printf("⚠️  無法移除舊的 crontab 項目: %v\n", err)
+		}
+		green.Printf("✅ 已安裝 systemd timer %s.timer，每天 %02d:%02d 執行備份\n", scheduleUnit, s.hour, s.minute)
+		fmt.Printf("執行紀錄：journalctl -u %s.service\n", scheduleUnit)
+	} else {
+		if err := s.installCron(*root); err != nil {
+			return err
+		}
+		green.Printf("✅ 已加入 crontab，每天 %02d:%02d 執行備份\n", s.hour, s.minute)
+		fmt.Printf("執行紀錄：%s\n", filepath.Join(*root, scheduleLogFile))
+	}
+	fmt.Printf("執行結果：%s（%s backup schedule status）\n", filepath.Join(*root, backupStatusFile), programName())
+	return nil
+}
+
+// systemdAvailable 檢查是否能以 root 安裝 systemd timer
+func systemdAvailable() bool {
+	if os.Geteuid() != 0 || !fileExists("/run/systemd/system") {
+		return false
+	}
+	_, err := exec.LookPath("systemctl")
+	return err == nil
+}
+
+func (s *backupSchedule) unitPath(suffix string) string {
+	return filepath.Join(systemdUnitDir, scheduleUnit+suffix)
+}
+
+func (s *backupSchedule) serviceUnit() string {
+	dir := strings.ReplaceAll(s.workDir, "%", "%%")
+	quoted := make([]string, len(s.args))
+	for i, a := range s.args {
+		quoted[i] = systemdQuote(a)
+	}
+	return fmt.Sprintf(`[Unit]
+Description=netiCRM scheduled backup (%s)
+Wants=network-online.target
+After=network-online.target docker.service
+
+[Service]
+Type=oneshot
+WorkingDirectory=%s
+ExecStart=%s
+Nice=10
+IOSchedulingClass=best-effort
+IOSchedulingPriority=7
+`, dir, dir, strings.Join(quoted, " "))
+}
+
+func (s *backupSchedule) timerUnit() string {
+	return fmt.Sprintf(`[Unit]
+Description=Daily netiCRM backup at %02d:%02d
+
+[Timer]
+OnCalendar=*-*-* %02d:%02d:00
+Persistent=true
+
+[Install]
+WantedBy=timers.target
+`, s.hour, s.minute, s.hour, s.minute)
+}
+
+func (s *backupSchedule) installSystemd() error {
+	// 同一台主機只有一組 neticrm-backup 單元，不覆蓋其他目錄的排程
+	if dir := systemdScheduleDir(); dir != "" && dir != s.workDir {
+		return fmt.Errorf("%s 已用於 %s 的排程備份，請先在該目錄執行 %s backup schedule remove", s.unitPath(".service"), dir, programName())
+	}
+
+	if err := os.WriteFile(s.unitPath(".service"), []byte(s.serviceUnit()), 0644); err != nil {
+		return fmt.Errorf("寫入 systemd 單元失敗: %w", err)
+	}
+	if err := os.WriteFile(s.unitPath(".timer"), []byte(s.timerUnit()), 0644); err != nil {
+		return fmt.Errorf("寫入 systemd 單元失敗: %w", err)
+	}
+	if err := systemctl("daemon-reload"); err != nil {
+		return err
+	}
+	return systemctl("enable", "--now", scheduleUnit+".timer")
+}
+
+// systemdScheduleDir 回傳已安裝的 systemd 排程所屬的網站目錄，未安裝時回傳空字串
+func systemdScheduleDir() string {
+	data, err := os.ReadFile(filepath.Join(systemdUnitDir, scheduleUnit+".service"))
+	if err != nil {
+		return ""
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if v, ok := strings.CutPrefix(line, "WorkingDirectory="); ok {
+			return strings.ReplaceAll(v, "%%", "%")
+		}
+	}
+	return ""
+}
+
+func systemctl(args ...string) error {
+	out, err := exec.Command("systemctl", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("systemctl %s 失敗: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// systemdQuote 以雙引號包住含空白或特殊字元的參數，並跳脫 systemd 的 % 與 $ 替換
+func systemdQuote(s string) string {
+	s = strings.NewReplacer("%", "%%", "$", "$$").Replace(s)
+	if !strings.ContainsAny(s, " \t\"'\\;") {
+		return s
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// cronLine 產生 crontab 項目，行尾的註解標記網站目錄，以便更新或移除
+func (s *backupSchedule) cronLine(root string) string {
+	quoted := make([]string, len(s.args))
+	for i, a := range s.args {
+		quoted[i] = cronQuote(a)
+	}
+	return fmt.Sprintf("%d %d * * * cd %s && %s >> %s 2>&1 %s%s",
+		s.minute, s.hour, cronQuote(s.workDir), strings.Join(quoted, " "),
+		cronQuote(filepath.Join(root, scheduleLogFile)), scheduleCronTag, s.workDir)
+}
+
+func (s *backupSchedule) installCron(root string) error {
+	// crontab 會將輸出附加到備份目錄下的執行紀錄，目錄不存在時 shell 無法開啟紀錄檔，備份不會執行
+	if err := os.MkdirAll(root, 0700); err != nil {
+		return fmt.Errorf("無法建立備份目錄 %s: %w", root, err)
+	}
+	lines, err := readCrontab()
+	if err != nil {
+		return err
+	}
+	lines = withoutCronEntry(lines, s.workDir)
+	lines = append(lines, s.cronLine(root))
+	return writeCrontab(lines)
+}
+
+// cronQuote 以單引號包住參數，並跳脫 crontab 中代表換行的 %
+func cronQuote(s string) string {
+	return "'" + strings.NewReplacer("'", `'\''`, "%", `\%`).Replace(s) + "'"
+}
+
+// readCrontab 讀取目前使用者的 crontab，沒有 crontab 時回傳空清單
+func readCrontab() ([]string, error) {
+	if _, err := exec.LookPath("crontab"); err != nil {
+		return nil, errors.New("找不到 crontab 命令，請安裝 cron")
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command("crontab", "-l")
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if strings.Contains(stderr.String(), "no crontab") {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("讀取 crontab 失敗: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.Split(strings.TrimRight(string(out), "\n"), "\n"), nil
+}
+
+func writeCrontab(lines []string) error {
+	cmd := exec.Command("crontab", "-")
+	cmd.Stdin = strings.NewReader(strings.Join(lines, "\n") + "\n")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("寫入 crontab 失敗: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func withoutCronEntry(lines []string, workDir string) []string {
+	var kept []string
+	for _, line := range lines {
+		if !strings.HasSuffix(line, scheduleCronTag+workDir) {
+			kept = append(kept, line)
+		}
+	}
+	return kept
+}
+
+// findCronEntry 回傳網站目錄的 crontab 項目，沒有時回傳空字串
+func findCronEntry(workDir string) string {
+	lines, err := readCrontab()
+	if err != nil {
+		return ""
+	}
+	for _, line := range lines {
+		if strings.HasSuffix(line, scheduleCronTag+workDir) {
+			return line
+		}
+	}
+	return ""
+}
+
+func removeCronEntry(workDir string) error {
+	if findCronEntry(workDir) == "" {
+		return nil
+	}
+	lines, err := readCrontab()
+	if err != nil {
+		return err
+	}
+	return writeCrontab(withoutCronEntry(lines, workDir))
+}
+
+// nextCronRun 計算「分 時 * * *」形式的下一次執行時間
+func nextCronRun(line string, now time.Time) (time.Time, bool) {
+	fields := strings.Fields(line)
+	if len(fields) < 5 || fields[2] != "*" || fields[3] != "*" || fields[4] != "*" {
+		return time.Time{}, false
+	}
+	minute, err1 := strconv.Atoi(fields[0])
+	hour, err2 := strconv.Atoi(fields[1])
+	if err1 != nil || err2 != nil {
+		return time.Time{}, false
+	}
+	next := time.Date(now.Year(), now.Month(), now.Day(), hour, minute, 0, 0, now.Location())
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next, true
+}
+
+func runBackupScheduleStatus(args []string) error {
+	fs := newFlagSet("backup schedule status")
+	root := fs.String("dir", backupDir, "備份目錄")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := noArgs(fs); err != nil {
+		return err
+	}
+
+	workDir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case systemdScheduleDir() == workDir:
+		fmt.Printf("排程方式：systemd（%s.timer）\n", scheduleUnit)
+		out, _ := exec.Command("systemctl", "is-enabled", scheduleUnit+".timer").Output()
+		fmt.Printf("狀態：%s\n", strings.TrimSpace(string(out)))
+		out, _ = exec.Command("systemctl", "show", scheduleUnit+".timer", "--property=NextElapseUSecRealtime", "--value").Output()
+		if next := strings.TrimSpace(string(out)); next != "" {
+			fmt.Printf("下次執行：%s\n", next)
+		}
+	case findCronEntry(workDir) != "":
+		line := findCronEntry(workDir)
+		fmt.Println("排程方式：crontab")
+		if next, ok := nextCronRun(line, time.Now()); ok {
+			fmt.Printf("下次執行：%s\n", next.Format("2006-01-02 15:04"))
+		}
+	default:
+		yellow.Printf("尚未設定排程備份，可執行 %s backup schedule 安裝\n", programName())
+	}
+
+	run, err := readBackupRunStatus(*root)
+	if os.IsNotExist(err) {
+		fmt.Println("上次執行：無紀錄")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("上次執行：%s（%s，耗時 %s）\n",
+		run.StartedAt.Local().Format("2006-01-02 15:04:05"), run.Reason, run.FinishedAt.Sub(run.StartedAt).Round(time.Second))
+	if run.SetID != "" {
+		fmt.Printf("備份組：%s\n", run.SetID)
+	}
+	if run.Target != "" {
+		uploaded := "未完成"
+		if run.Uploaded {
+			uploaded = "完成"
+		}
+		fmt.Printf("上傳至：%s（%s）\n", run.Target, uploaded)
+	}
+	if run.Status != statusSuccess {
+		red.Printf("結果：失敗 — %s\n", run.Error)
+		return errors.New("上次備份失敗")
+	}
+	green.Println("結果：成功")
+	return nil
+}
+
+func runBackupScheduleRemove(args []string) error {
+	fs := newFlagSet("backup schedule remove")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := noArgs(fs); err != nil {
+		return err
+	}
+
+	workDir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	removed := false
+	if systemdScheduleDir() == workDir {
+		if err := systemctl("disable", "--now", scheduleUnit+".timer"); err != nil {
+			return err
+		}
+		for _, suffix := range []string{".service", ".timer"} {
+			if err := os.Remove(filepath.Join(systemdUnitDir, scheduleUnit+suffix)); err != nil {
+				return err
+			}
+		}
+		if err := systemctl("daemon-reload"); err != nil {
+			return err
+		}
+		green.Printf("✅ 已移除 systemd timer %s.timer\n", scheduleUnit)
+		removed = true
+	}
+	if findCronEntry(workDir) != "" {
+		if err := removeCronEntry(workDir); err != nil {
+			return err
+		}
+		green.Println("✅ 已移除 crontab 項目")
+		removed = true
+	}
+	if !removed {
+		fmt.Println("此目錄沒有排程備份。")
+	}
+	return nil
+}
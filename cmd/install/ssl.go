@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/netivism/neticrm-selfhost/internal/envfile"
+)
+
+// .env 中 SSL_MODE 的值
+const (
+	// sslModeCaddy 由 Caddy 在 80 與 443 埠提供 HTTPS，使用 docker-compose-ssl.yaml
+	sslModeCaddy = "caddy"
+	// sslModeOff 由 nginx 在 HTTP_PORT 提供 HTTP，使用 docker-compose.yaml
+	sslModeOff = "off"
+)
+
+// sslMode 回傳 SSL_MODE 對應的值
+func sslMode(useSSL bool) string {
+	if useSSL {
+		return sslModeCaddy
+	}
+	return sslModeOff
+}
+
+// envUsesSSL 依 SSL_MODE 判斷是否使用 SSL；舊版安裝程式沒有記錄 SSL_MODE，此時以 Caddyfile 是否存在判斷
+func envUsesSSL(env *envfile.File) bool {
+	if mode, _ := env.Get("SSL_MODE"); mode != "" {
+		return mode == sslModeCaddy
+	}
+	return fileExists(caddyfile)
+}
+
+// sslEnabled 回傳目前的網站是否使用 SSL
+func sslEnabled() bool {
+	env, err := envfile.Load(targetFile)
+	if err != nil {
+		return fileExists(caddyfile)
+	}
+	return envUsesSSL(env)
+}
+
+func runSSL(args []string) error {
+	fs := newFlagSet("ssl")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := noArgs(fs); err != nil {
+		return err
+	}
+	if err := requireInstalled(); err != nil {
+		return err
+	}
+
+	cfg, err := loadCurrentConfig()
+	if err != nil {
+		return err
+	}
+	if cfg.UseSSL {
+		green.Printf("SSL 已啟用（%s），由 Caddy 向 %s 取得 %s 的憑證\n", sslModeCaddy, acmeCAName(cfg.ACMECA), cfg.Domain)
+		if cfg.ACMECA != "" {
+			fmt.Printf("改回 Let's Encrypt 正式環境：%s reconfigure --acme-ca production\n", programName())
+		}
+		fmt.Printf("停用 SSL：%s ssl disable\n", programName())
+	} else {
+		yellow.Printf("SSL 未啟用（%s），由 nginx 在 %s 埠提供 HTTP\n", sslModeOff, displayValue(cfg.Port))
+		fmt.Printf("啟用 SSL：%s ssl enable --domain 網域 --email 信箱\n", programName())
+	}
+	fmt.Printf("網站網址：%s\n", siteURL(cfg))
+	return nil
+}
+
+func runSSLEnable(args []string) error {
+	fs := newFlagSet("ssl enable")
+	domain := fs.String("domain", "", "網站網域（預設為 .env 的 DOMAIN）")
+	email := fs.String("email", "", "Let's Encrypt 憑證使用的電子郵件")
+	acmeCA := fs.String("acme-ca", "", "Caddy 申請憑證的 ACME CA：production、staging 或 ACME 目錄網址（預設 production）")
+	acmeCARoot := fs.String("acme-ca-root", "", "自訂 ACME CA 的根憑證 PEM 檔")
+	yes := fs.Bool("yes", false, "不詢問確認，直接啟用")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := noArgs(fs); err != nil {
+		return err
+	}
+	if err := requireInstalled(); err != nil {
+		return err
+	}
+
+	before, err := loadCurrentConfig()
+	if err != nil {
+		return err
+	}
+	if before.UseSSL {
+		green.Printf("SSL 已經啟用（%s）。\n", before.Domain)
+		return nil
+	}
+
+	after := *before
+	after.UseSSL = true
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "domain":
+			after.Domain = *domain
+		case "email":
+			after.Email = *email
+		case "acme-ca":
+			after.ACMECA = *acmeCA
+		case "acme-ca-root":
+			after.ACMECARoot = *acmeCARoot
+		}
+	})
+	if after.Domain == "" {
+		return newUsageError("目前沒有設定網域，請以 --domain 指定")
+	}
+	if err := validateReconfigure(before, &after); err != nil {
+		return usageError{msg: err.Error()}
+	}
+	return confirmAndApply(before, &after, *yes)
+}
+
+func runSSLDisable(args []string) error {
+	fs := newFlagSet("ssl disable")
+	port := fs.String("port", "", "改由 nginx 提供 HTTP 的埠（預設為 .env 的 HTTP_PORT，沒有時為 8080）")
+	yes := fs.Bool("yes", false, "不詢問確認，直接停用")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := noArgs(fs); err != nil {
+		return err
+	}
+	if err := requireInstalled(); err != nil {
+		return err
+	}
+
+	before, err := loadCurrentConfig()
+	if err != nil {
+		return err
+	}
+	if !before.UseSSL {
+		green.Println("SSL 已經停用。")
+		return nil
+	}
+
+	after := *before
+	after.UseSSL = false
+	if *port != "" {
+		after.Port = *port
+	}
+	if err := validateReconfigure(before, &after); err != nil {
+		return usageError{msg: err.Error()}
+	}
+	return confirmAndApply(before, &after, *yes)
+}
+
+// smokeTest 由本機連線到網站首頁，直到取得非 5xx 的回應或逾時；HTTPS 會驗證 Let's Encrypt 正式環境的憑證
+func smokeTest(cfg *Config, timeout time.Duration) error {
+	target := siteURL(cfg)
+
+	// 網域不一定已指向此主機，一律連線到本機的對外埠
+	host, port := "127.0.0.1", "443"
+	if !cfg.UseSSL {
+		port = cfg.Port
+		if port == "" {
+			port = "80"
+		}
+		switch bind := cfg.HTTPBind; bind {
+		case "", "0.0.0.0":
+		case "::":
+			host = "::1"
+		default:
+			host = bind
+		}
+	}
+	addr := net.JoinHostPort(host, port)
+
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	client := &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, addr)
+			},
+			// staging 與自訂 CA 簽發的憑證不在系統的信任清單中
+			TLSClientConfig: &tls.Config{ServerName: cfg.Domain, InsecureSkipVerify: cfg.ACMECA != ""},
+		},
+		// Drupal 可能轉址到語言或登入頁，只檢查第一個回應
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	cyan.Printf("檢查 %s（連線到 %s）...\n", target, addr)
+	deadline := time.Now().Add(timeout)
+	var lastErr error
+	for {
+		resp, err := client.Get(target + "/")
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode < 500 {
+				green.Printf("✓ %s 回應 %s\n", target, resp.Status)
+				return nil
+			}
+			err = fmt.Errorf("回應 %s", resp.Status)
+		}
+		lastErr = err
+		if time.Now().After(deadline) {
+			return fmt.Errorf("無法連線到 %s: %v", target, lastErr)
+		}
+		time.Sleep(5 * time.Second)
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/netivism/neticrm-selfhost/internal/envfile"
+)
+
+// backupTarget 是備份上傳的遠端目的地
+type backupTarget interface {
+	// String 回傳不含密碼的目的地描述
+	String() string
+	// Upload 將本機檔案上傳為 name（相對於目的地根目錄，以 / 分隔）。
+	// 中斷後再次呼叫會從已上傳的部分續傳，完成後以 sha256 驗證遠端內容。
+	Upload(localPath, name, sha256sum string) error
+	Close() error
+}
+
+// newBackupTarget 依 BACKUP_TARGET 格式建立目的地：
+//
+//	s3://bucket/prefix               S3 相容物件儲存
+//	sftp://user@host:port/path       SFTP
+//	file:///mnt/backup 或 /mnt/backup 已掛載的目錄
+func newBackupTarget(spec string, env map[string]string) (backupTarget, error) {
+	if strings.HasPrefix(spec, "/") {
+		return &localTarget{root: filepath.Clean(spec)}, nil
+	}
+
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, fmt.Errorf("無法解析備份目的地 %q: %w", spec, err)
+	}
+
+	switch u.Scheme {
+	case "file":
+		if u.Path == "" {
+			return nil, fmt.Errorf("備份目的地 %q 缺少路徑", spec)
+		}
+		return &localTarget{root: filepath.Clean(u.Path)}, nil
+	case "s3":
+		return newS3Target(u, env)
+	case "sftp":
+		return newSFTPTarget(u, env)
+	}
+	return nil, fmt.Errorf("不支援的備份目的地 %q（支援 s3://、sftp://、file:// 或絕對路徑）", spec)
+}
+
+// backupSetFiles 回傳備份組中可以上傳的檔案：只限 backup 建立的封存檔與說明檔，backup.json 放在最後。
+// 其他命令的備份組含有 .env 與設定檔的原始複本，內有資料庫與管理員密碼，不可離開主機
+func backupSetFiles(set *backupSet) ([]string, error) {
+	var files []string
+	for _, item := range set.Items {
+		if item.Source != "" || item.External {
+			return nil, fmt.Errorf("備份組 %s（%s）含有 %s 的原始複本，只能上傳由 %s backup 建立的備份組", set.ID, set.Reason, item.Source, programName())
+		}
+		files = append(files, item.Path)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("備份組 %s 沒有封存檔", set.ID)
+	}
+	return append(files, backupSetMetadata), nil
+}
+
+// uploadBackupSet 上傳備份組的封存檔與說明檔到目的地的 <備份組 ID>/ 之下
+func uploadBackupSet(set *backupSet, target backupTarget) error {
+	// backup.json 最後上傳，遠端有 backup.json 代表整組已上傳完成
+	files, err := backupSetFiles(set)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range files {
+		local := set.path(filepath.FromSlash(name))
+		sum, size, err := sha256File(local)
+		if err != nil {
+			return err
+		}
+		cyan.Printf("上傳 %s（%s）至 %s ...\n", name, formatBytes(size), target)
+		if err := target.Upload(local, path.Join(set.ID, name), sum); err != nil {
+			return fmt.Errorf("上傳 %s 失敗: %w", name, err)
+		}
+	}
+
+	green.Printf("✅ 備份組 %s 已上傳至 %s 並通過 SHA-256 驗證\n", set.ID, target)
+	return nil
+}
+
+// backupTargetSpec 回傳命令列或 .env 設定的備份目的地
+func backupTargetSpec(flagValue string, env map[string]string) string {
+	if flagValue != "" {
+		return flagValue
+	}
+	return env["BACKUP_TARGET"]
+}
+
+func runBackupUpload(args []string) error {
+	fs := newFlagSet("backup upload")
+	root := fs.String("dir", backupDir, "備份目錄")
+	targetFlag := fs.String("target", "", "備份目的地（預設使用 .env 的 BACKUP_TARGET）")
+	allowUnencrypted := fs.Bool("allow-unencrypted", false, "仍上傳未加密的備份組")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		return newUsageError("只能指定一個備份組")
+	}
+	if err := requireInstalled(); err != nil {
+		return err
+	}
+
+	env, err := envfile.ReadFile(targetFile)
+	if err != nil {
+		return fmt.Errorf("讀取 %s 失敗: %w", targetFile, err)
+	}
+	spec := backupTargetSpec(*targetFlag, env)
+	if spec == "" {
+		return newUsageError("未設定備份目的地，請使用 --target 或在 .env 設定 BACKUP_TARGET")
+	}
+
+	sets, err := listBackupSets(*root)
+	if err != nil {
+		return err
+	}
+	// 未指定時上傳最新一個由 backup 建立的備份組
+	var set *backupSet
+	for _, s := range sets {
+		if fs.NArg() == 0 {
+			if _, err := backupSetFiles(s); err != nil {
+				continue
+			}
+		} else if s.ID != fs.Arg(0) {
+			continue
+		}
+		set = s
+		break
+	}
+	if set == nil && fs.NArg() == 0 {
+		return fmt.Errorf("%s 中沒有由 %s backup 建立的備份組", *root, programName())
+	}
+	if set == nil {
+		return fmt.Errorf("找不到備份組 %s", fs.Arg(0))
+	}
+	if _, err := backupSetFiles(set); err != nil {
+		return err
+	}
+
+	target, err := newBackupTarget(spec, env)
+	if err != nil {
+		return err
+	}
+	defer target.Close()
+	if set.Encryption == nil && !*allowUnencrypted {
+		return unencryptedUploadError(target.String())
+	}
+
+	return uploadBackupSet(set, target)
+}
+
+// localTarget 將備份複製到已掛載的目錄（NFS、外接硬碟等）
+type localTarget struct {
+	root string
+}
+
+func (t *localTarget) String() string { return "file://" + t.root }
+func (t *localTarget) Close() error   { return nil }
+
+func (t *localTarget) Upload(localPath, name, sha256sum string) error {
+	dest := filepath.Join(t.root, filepath.FromSlash(name))
+	if got, _, err := sha256File(dest); err == nil && got == sha256sum {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0700); err != nil {
+		return err
+	}
+
+	partial := dest + ".partial"
+	dst, err := os.OpenFile(partial, os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	if err := resumeCopy(dst, localPath); err != nil {
+		return err
+	}
+	if err := dst.Sync(); err != nil {
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+
+	got, _, err := sha256File(partial)
+	if err != nil {
+		return err
+	}
+	if got != sha256sum {
+		os.Remove(partial)
+		return fmt.Errorf("目的地檔案 SHA-256 不符，已刪除不完整的檔案，請重新上傳")
+	}
+	return os.Rename(partial, dest)
+}
+
+// seekWriter 是可續傳寫入的遠端或本機檔案
+type seekWriter interface {
+	io.Writer
+	io.Seeker
+	Truncate(size int64) error
+}
+
+// resumeCopy 從 dst 目前的大小開始，將 src 剩下的內容寫入 dst
+func resumeCopy(dst seekWriter, src string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	offset, err := dst.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if offset > info.Size() {
+		// 目的地比來源大，不可能是同一個檔案，從頭開始
+		if err := dst.Truncate(0); err != nil {
+			return err
+		}
+		offset = 0
+	}
+	if offset > 0 {
+		fmt.Printf("  從 %s 處續傳\n", formatBytes(offset))
+	}
+
+	if _, err := dst.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, f)
+	return err
+}
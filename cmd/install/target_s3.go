@@ -0,0 +1,474 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	s3PartSize          = 16 << 20
+	s3UploadStateSuffix = ".s3upload"
+)
+
+// s3Target 上傳到 S3 相容的物件儲存（AWS S3、MinIO 等），大檔以 multipart 上傳並可續傳
+type s3Target struct {
+	endpoint  *url.URL
+	region    string
+	bucket    string
+	prefix    string
+	accessKey string
+	secretKey string
+	pathStyle bool
+	client    *http.Client
+}
+
+func newS3Target(u *url.URL, env map[string]string) (*s3Target, error) {
+	t := &s3Target{
+		bucket:    u.Host,
+		prefix:    strings.Trim(u.Path, "/"),
+		region:    envOrDefault(env, "BACKUP_S3_REGION", "us-east-1"),
+		accessKey: env["BACKUP_S3_ACCESS_KEY"],
+		secretKey: env["BACKUP_S3_SECRET_KEY"],
+		client:    &http.Client{Timeout: 10 * time.Minute},
+	}
+	if t.bucket == "" {
+		return nil, fmt.Errorf("S3 備份目的地缺少 bucket，例如 s3://my-bucket/neticrm")
+	}
+	if t.accessKey == "" || t.secretKey == "" {
+		return nil, fmt.Errorf("請在 .env 設定 BACKUP_S3_ACCESS_KEY 與 BACKUP_S3_SECRET_KEY")
+	}
+
+	endpoint := env["BACKUP_S3_ENDPOINT"]
+	if endpoint == "" {
+		endpoint = "https://s3." + t.region + ".amazonaws.com"
+	} else {
+		// 自訂端點（MinIO 等）預設使用 path-style
+		t.pathStyle = true
+	}
+	if v := env["BACKUP_S3_PATH_STYLE"]; v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("BACKUP_S3_PATH_STYLE 必須是 true 或 false: %q", v)
+		}
+		t.pathStyle = b
+	}
+
+	ep, err := url.Parse(endpoint)
+	if err != nil || ep.Host == "" || (ep.Scheme != "http" && ep.Scheme != "https") {
+		return nil, fmt.Errorf("BACKUP_S3_ENDPOINT 不是有效的網址: %q", endpoint)
+	}
+	t.endpoint = ep
+
+	return t, nil
+}
+
+func (t *s3Target) String() string {
+	return fmt.Sprintf("s3://%s/%s（%s）", t.bucket, t.prefix, t.endpoint.Host)
+}
+
+func (t *s3Target) Close() error { return nil }
+
+func (t *s3Target) key(name string) string {
+	if t.prefix == "" {
+		return name
+	}
+	return t.prefix + "/" + name
+}
+
+// s3UploadState 記錄未完成的 multipart 上傳，供中斷後續傳
+type s3UploadState struct {
+	Bucket   string `json:"bucket"`
+	Key      string `json:"key"`
+	UploadID string `json:"upload_id"`
+	SHA256   string `json:"sha256"`
+}
+
+func (t *s3Target) Upload(localPath, name, sha256sum string) error {
+	key := t.key(name)
+
+	// 遠端已有相同內容則略過
+	if meta, _, err := t.head(key); err == nil && meta == sha256sum {
+		return nil
+	}
+
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return err
+	}
+	if info.Size() <= s3PartSize {
+		return t.putObject(localPath, key, sha256sum)
+	}
+	return t.multipartUpload(localPath, key, sha256sum, info.Size())
+}
+
+// putObject 以單一請求上傳小檔，伺服器會驗證 Content-MD5 與 payload SHA-256
+func (t *s3Target) putObject(localPath, key, sha256sum string) error {
+	data, err := os.ReadFile(localPath)
+	if err != nil {
+		return err
+	}
+	md5sum := md5.Sum(data)
+
+	header := http.Header{}
+	header.Set("Content-MD5", base64.StdEncoding.EncodeToString(md5sum[:]))
+	header.Set("X-Amz-Meta-Sha256", sha256sum)
+
+	resp, err := t.do(http.MethodPut, key, nil, header, data)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	return t.verify(key, sha256sum, `"`+hex.EncodeToString(md5sum[:])+`"`)
+}
+
+func (t *s3Target) multipartUpload(localPath, key, sha256sum string, size int64) error {
+	statePath := localPath + s3UploadStateSuffix
+	state := t.loadUploadState(statePath, key, sha256sum)
+
+	uploaded := map[int]string{}
+	if state != nil {
+		parts, err := t.listParts(key, state.UploadID)
+		if err != nil {
+			// 上傳已過期或被清除，重新開始
+			state = nil
+		} else {
+			uploaded = parts
+			fmt.Printf("  續傳先前的上傳（已完成 %d 個分段）\n", len(parts))
+		}
+	}
+	if state == nil {
+		id, err := t.createMultipartUpload(key, sha256sum)
+		if err != nil {
+			return err
+		}
+		state = &s3UploadState{Bucket: t.bucket, Key: key, UploadID: id, SHA256: sha256sum}
+		if err := writeJSONFile(statePath, state); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var etags []string
+	var md5s []byte
+	buf := make([]byte, s3PartSize)
+	parts := int((size + s3PartSize - 1) / s3PartSize)
+	for n := 1; n <= parts; n++ {
+		m, err := io.ReadFull(f, buf)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		chunk := buf[:m]
+		sum := md5.Sum(chunk)
+		etag := `"` + hex.EncodeToString(sum[:]) + `"`
+		md5s = append(md5s, sum[:]...)
+		etags = append(etags, etag)
+
+		// 已上傳且 MD5 相同的分段不需重傳
+		if uploaded[n] == etag {
+			continue
+		}
+
+		query := url.Values{"partNumber": {strconv.Itoa(n)}, "uploadId": {state.UploadID}}
+		header := http.Header{}
+		header.Set("Content-MD5", base64.StdEncoding.EncodeToString(sum[:]))
+		resp, err := t.do(http.MethodPut, key, query, header, chunk)
+		if err != nil {
+			return fmt.Errorf("上傳第 %d/%d 段失敗（可再次執行以續傳）: %w", n, parts, err)
+		}
+		resp.Body.Close()
+		if got := resp.Header.Get("ETag"); got != etag {
+			return fmt.Errorf("第 %d 段 ETag 不符（%s ≠ %s）", n, got, etag)
+		}
+		fmt.Printf("  已上傳 %d/%d 段\n", n, parts)
+	}
+
+	if err := t.completeMultipartUpload(key, state.UploadID, etags); err != nil {
+		return err
+	}
+	os.Remove(statePath)
+
+	total := md5.Sum(md5s)
+	return t.verify(key, sha256sum, fmt.Sprintf(`"%s-%d"`, hex.EncodeToString(total[:]), parts))
+}
+
+func (t *s3Target) loadUploadState(path, key, sha256sum string) *s3UploadState {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	state := &s3UploadState{}
+	if json.Unmarshal(data, state) != nil || state.Bucket != t.bucket || state.Key != key || state.SHA256 != sha256sum {
+		return nil
+	}
+	return state
+}
+
+// verify 以 HEAD 確認物件的 ETag 與記錄的 SHA-256
+func (t *s3Target) verify(key, sha256sum, etag string) error {
+	meta, gotETag, err := t.head(key)
+	if err != nil {
+		return fmt.Errorf("上傳後無法讀取物件資訊: %w", err)
+	}
+	if meta != sha256sum {
+		return fmt.Errorf("物件的 SHA-256 中繼資料不符")
+	}
+	// 部分 S3 相容服務的 multipart ETag 不是 md5-of-md5s-N 格式，此時各分段已逐一驗證過 ETag，
+	// 只依 SHA-256 中繼資料判斷
+	if strings.Contains(etag, "-") && !strings.Contains(gotETag, "-") {
+		return nil
+	}
+	if !strings.EqualFold(gotETag, etag) {
+		return fmt.Errorf("物件 ETag 不符（%s ≠ %s），上傳內容可能已損毀", gotETag, etag)
+	}
+	return nil
+}
+
+func (t *s3Target) head(key string) (sha256sum, etag string, err error) {
+	resp, err := t.do(http.MethodHead, key, nil, nil, nil)
+	if err != nil {
+		return "", "", err
+	}
+	resp.Body.Close()
+	return resp.Header.Get("X-Amz-Meta-Sha256"), resp.Header.Get("ETag"), nil
+}
+
+func (t *s3Target) createMultipartUpload(key, sha256sum string) (string, error) {
+	header := http.Header{}
+	header.Set("X-Amz-Meta-Sha256", sha256sum)
+	resp, err := t.do(http.MethodPost, key, url.Values{"uploads": {""}}, header, nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil || result.UploadID == "" {
+		return "", fmt.Errorf("無法建立 multipart 上傳: %v", err)
+	}
+	return result.UploadID, nil
+}
+
+// listParts 回傳已上傳的分段編號與 ETag
+func (t *s3Target) listParts(key, uploadID string) (map[int]string, error) {
+	parts := map[int]string{}
+	marker := ""
+	for {
+		query := url.Values{"uploadId": {uploadID}}
+		if marker != "" {
+			query.Set("part-number-marker", marker)
+		}
+		resp, err := t.do(http.MethodGet, key, query, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var result struct {
+			IsTruncated          bool   `xml:"IsTruncated"`
+			NextPartNumberMarker string `xml:"NextPartNumberMarker"`
+			Parts                []struct {
+				PartNumber int    `xml:"PartNumber"`
+				ETag       string `xml:"ETag"`
+			} `xml:"Part"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, p := range result.Parts {
+			parts[p.PartNumber] = p.ETag
+		}
+		if !result.IsTruncated || result.NextPartNumberMarker == "" {
+			return parts, nil
+		}
+		marker = result.NextPartNumberMarker
+	}
+}
+
+func (t *s3Target) completeMultipartUpload(key, uploadID string, etags []string) error {
+	var body bytes.Buffer
+	body.WriteString("<CompleteMultipartUpload>")
+	for i, etag := range etags {
+		fmt.Fprintf(&body, "<Part><PartNumber>%d</PartNumber><ETag>%s</ETag></Part>", i+1, etag)
+	}
+	body.WriteString("</CompleteMultipartUpload>")
+
+	resp, err := t.do(http.MethodPost, key, url.Values{"uploadId": {uploadID}}, nil, body.Bytes())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// 完成 multipart 上傳時，錯誤可能在 200 回應的內容中
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if bytes.Contains(data, []byte("<Error>")) {
+		return fmt.Errorf("完成 multipart 上傳失敗: %s", s3ErrorMessage(data))
+	}
+	return nil
+}
+
+// do 送出以 AWS Signature Version 4 簽章的請求，非 2xx 回應轉為錯誤
+func (t *s3Target) do(method, key string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	u := *t.endpoint
+	if t.pathStyle {
+		u.Path = "/" + t.bucket + "/" + key
+	} else {
+		u.Host = t.bucket + "." + u.Host
+		u.Path = "/" + key
+	}
+	u.RawPath = s3EscapePath(u.Path)
+	u.RawQuery = s3CanonicalQuery(query)
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	t.sign(req, body, time.Now().UTC())
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		data, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, fmt.Errorf("S3 %s %s 回應 %s: %s", method, key, resp.Status, s3ErrorMessage(data))
+	}
+	return resp, nil
+}
+
+func (t *s3Target) sign(req *http.Request, body []byte, now time.Time) {
+	payload := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(payload[:])
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		lk := strings.ToLower(k)
+		if strings.HasPrefix(lk, "x-amz-") || lk == "content-md5" || lk == "content-type" {
+			headers[lk] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + t.region + "/s3/aws4_request"
+	hashed := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hashed[:])
+
+	key := hmacSHA256([]byte("AWS4"+t.secretKey), date)
+	key = hmacSHA256(key, t.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		t.accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3Escape 依 SigV4 規則編碼，只保留 A-Z a-z 0-9 - _ . ~
+func s3Escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func s3EscapePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, s := range segments {
+		segments[i] = s3Escape(s)
+	}
+	return strings.Join(segments, "/")
+}
+
+func s3CanonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, s3Escape(k)+"="+s3Escape(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func s3ErrorMessage(data []byte) string {
+	var e struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	if xml.Unmarshal(data, &e) == nil && e.Code != "" {
+		return e.Code + ": " + e.Message
+	}
+	return strings.TrimSpace(string(data))
+}
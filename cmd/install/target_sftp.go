@@ -0,0 +1,177 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// sftpTarget 以 SFTP 上傳，中斷後由遠端暫存檔的大小續傳
+type sftpTarget struct {
+	user   string
+	addr   string
+	root   string
+	conn   *ssh.Client
+	client *sftp.Client
+}
+
+func newSFTPTarget(u *url.URL, env map[string]string) (*sftpTarget, error) {
+	t := &sftpTarget{
+		user: u.User.Username(),
+		addr: u.Host,
+		root: u.Path,
+	}
+	if u.Hostname() == "" || t.user == "" {
+		return nil, fmt.Errorf("SFTP 備份目的地格式為 sftp://user@host:port/path")
+	}
+	if u.Port() == "" {
+		t.addr = net.JoinHostPort(u.Hostname(), "22")
+	}
+	if t.root == "" {
+		t.root = "."
+	}
+
+	var auth []ssh.AuthMethod
+	if keyPath := env["BACKUP_SFTP_KEY"]; keyPath != "" {
+		key, err := os.ReadFile(keyPath)
+		if err != nil {
+			return nil, fmt.Errorf("讀取 BACKUP_SFTP_KEY 失敗: %w", err)
+		}
+		var signer ssh.Signer
+		if pass := env["BACKUP_SFTP_KEY_PASSPHRASE"]; pass != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(key, []byte(pass))
+		} else {
+			signer, err = ssh.ParsePrivateKey(key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("無法解析 SFTP 私鑰 %s: %w", keyPath, err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if pass := env["BACKUP_SFTP_PASSWORD"]; pass != "" {
+		auth = append(auth, ssh.Password(pass))
+	}
+	if len(auth) == 0 {
+		return nil, fmt.Errorf("請在 .env 設定 BACKUP_SFTP_KEY 或 BACKUP_SFTP_PASSWORD")
+	}
+
+	hostKeyCallback, err := sftpHostKeyCallback(env)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := ssh.Dial("tcp", t.addr, &ssh.ClientConfig{
+		User:            t.user,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         30 * time.Second,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("無法連線到 SFTP %s: %w", t.addr, err)
+	}
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("無法啟動 SFTP 工作階段: %w", err)
+	}
+	t.conn, t.client = conn, client
+
+	return t, nil
+}
+
+// sftpHostKeyCallback 以 BACKUP_SFTP_HOST_KEY 指紋或 known_hosts 驗證主機金鑰
+func sftpHostKeyCallback(env map[string]string) (ssh.HostKeyCallback, error) {
+	if fp := env["BACKUP_SFTP_HOST_KEY"]; fp != "" {
+		return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			if got := ssh.FingerprintSHA256(key); got != fp {
+				return fmt.Errorf("SFTP 主機金鑰指紋 %s 與 BACKUP_SFTP_HOST_KEY 不符", got)
+			}
+			return nil
+		}, nil
+	}
+
+	knownHosts := env["BACKUP_SFTP_KNOWN_HOSTS"]
+	if knownHosts == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		knownHosts = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	callback, err := knownhosts.New(knownHosts)
+	if err != nil {
+		return nil, fmt.Errorf("無法讀取 known_hosts（%s），請設定 BACKUP_SFTP_KNOWN_HOSTS 或 BACKUP_SFTP_HOST_KEY: %w", knownHosts, err)
+	}
+	return callback, nil
+}
+
+func (t *sftpTarget) String() string {
+	return fmt.Sprintf("sftp://%s@%s%s", t.user, t.addr, t.root)
+}
+
+func (t *sftpTarget) Close() error {
+	t.client.Close()
+	return t.conn.Close()
+}
+
+func (t *sftpTarget) Upload(localPath, name, sha256sum string) error {
+	dest := path.Join(t.root, name)
+	if got, err := t.remoteSHA256(dest); err == nil && got == sha256sum {
+		return nil
+	}
+
+	if err := t.client.MkdirAll(path.Dir(dest)); err != nil {
+		return fmt.Errorf("無法建立遠端目錄: %w", err)
+	}
+
+	partial := dest + ".partial"
+	f, err := t.client.OpenFile(partial, os.O_CREATE|os.O_WRONLY)
+	if err != nil {
+		return fmt.Errorf("無法開啟遠端檔案: %w", err)
+	}
+	if err := resumeCopy(f, localPath); err != nil {
+		f.Close()
+		return fmt.Errorf("上傳中斷（可再次執行以續傳）: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	// 讀回遠端檔案驗證內容
+	got, err := t.remoteSHA256(partial)
+	if err != nil {
+		return err
+	}
+	if got != sha256sum {
+		t.client.Remove(partial)
+		return fmt.Errorf("遠端檔案 SHA-256 不符，已刪除不完整的檔案，請重新上傳")
+	}
+
+	t.client.Remove(dest)
+	return t.client.Rename(partial, dest)
+}
+
+func (t *sftpTarget) remoteSHA256(p string) (string, error) {
+	f, err := t.client.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AlecAivazis/survey/v2"
+	"github.com/netivism/neticrm-selfhost/internal/envfile"
+)
+
+const neticrmRepo = "NETivism/netiCRM"
+
+var neticrmVersionPattern = regexp.MustCompile(`^[0-9]+(\.[0-9]+)*$`)
+
+var releaseClient = &http.Client{Timeout: 15 * time.Second}
+
+// normalizeNeticrmVersion 去除 v 前綴並檢查版本格式，例如 v6.2.1 => 6.2.1
+func normalizeNeticrmVersion(v string) (string, error) {
+	v = strings.TrimPrefix(strings.TrimSpace(v), "v")
+	if !neticrmVersionPattern.MatchString(v) {
+		return "", fmt.Errorf("netiCRM 版本格式不正確：%q（例如 6.2.1）", v)
+	}
+	return v, nil
+}
+
+// latestNeticrmVersion 由 GitHub API 取得最新發行版本，與 container/init-10.sh 相同
+func latestNeticrmVersion() (string, error) {
+	resp, err := releaseClient.Get("https://api.github.com/repos/" + neticrmRepo + "/releases/latest")
+	if err != nil {
+		return "", fmt.Errorf("無法查詢 netiCRM 最新版本: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("無法查詢 netiCRM 最新版本: GitHub 回應 %s", resp.Status)
+	}
+
+	var release struct {
+		TagName string `json:"tag_name"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&release); err != nil {
+		return "", fmt.Errorf("無法解析 GitHub 回應: %w", err)
+	}
+	return normalizeNeticrmVersion(release.TagName)
+}
+
+func neticrmDownloadURL(v string) string {
+	return fmt.Sprintf("https://github.com/%s/releases/download/%s/neticrm-%s.tar.gz", neticrmRepo, v, v)
+}
+
+// checkNeticrmRelease 確認指定版本的發行檔可以下載
+func checkNeticrmRelease(v string) error {
+	resp, err := releaseClient.Head(neticrmDownloadURL(v))
+	if err != nil {
+		return fmt.Errorf("無法連線到 GitHub: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("找不到 netiCRM %s 的發行檔（%s）", v, resp.Status)
+	}
+	return nil
+}
+
+// compareVersions 逐段比較版本號，a < b 回傳 -1，相同回傳 0，a > b 回傳 1
+func compareVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+	}
+	return 0
+}
+
+// pinNeticrmVersion 未指定版本時以目前最新版寫入 .env，讓之後重建容器也安裝同一版本
+func pinNeticrmVersion(cfg *Config) {
+	if cfg.NeticrmVersion != "" {
+		return
+	}
+	latest, err := latestNeticrmVersion()
+	if err != nil {
+		yellow.Printf("⚠️  %v，首次啟動時將安裝當時的最新版\n", err)
+		return
+	}
+	cfg.NeticrmVersion = latest
+}
+
+func askNeticrmVersion(cfg *Config) error {
+	message := "netiCRM version to install:"
+	if cfg.Language == "zh-hant" {
+		message = "要安裝的 netiCRM 版本："
+	}
+
+	latest, err := latestNeticrmVersion()
+	if err != nil {
+		yellow.Printf("⚠️  %v\n", err)
+		message = "netiCRM version to install (leave blank for the latest release at first boot):"
+		if cfg.Language == "zh-hant" {
+			message = "要安裝的 netiCRM 版本（留空則於首次啟動時安裝最新版）："
+		}
+	}
+
+	prompt := &survey.Input{
+		Message: message,
+		Default: latest,
+	}
+	validator := func(ans interface{}) error {
+		if s, _ := ans.(string); s != "" {
+			_, err := normalizeNeticrmVersion(s)
+			return err
+		}
+		return nil
+	}
+	var answer string
+	if err := survey.AskOne(prompt, &answer, survey.WithValidator(validator)); err != nil {
+		return err
+	}
+	if answer != "" {
+		cfg.NeticrmVersion, _ = normalizeNeticrmVersion(answer)
+	}
+	return nil
+}
+
+func runUpgrade(args []string) error {
+	fs := newFlagSet("upgrade")
+	to := fs.String("to", "", "升級到的 netiCRM 版本（預設為最新版）")
+	yes := fs.Bool("yes", false, "不詢問確認，直接升級")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := noArgs(fs); err != nil {
+		return err
+	}
+	if *to != "" {
+		v, err := normalizeNeticrmVersion(*to)
+		if err != nil {
+			return usageError{msg: err.Error()}
+		}
+		*to = v
+	}
+	if err := requireInstalled(); err != nil {
+		return err
+	}
+	if err := checkDocker(); err != nil {
+		return err
+	}
+	if !containerRunning(phpContainer) {
+		return fmt.Errorf("容器 %s 未執行，請先執行 %s start", phpContainer, programName())
+	}
+
+	env, err := envfile.ReadFile(targetFile)
+	if err != nil {
+		return fmt.Errorf("讀取 %s 失敗: %w", targetFile, err)
+	}
+
+	target := *to
+	if target == "" {
+		if target, err = latestNeticrmVersion(); err != nil {
+			return err
+		}
+	}
+	current := detectNeticrmVersion()
+	if current != "" {
+		switch compareVersions(target, current) {
+		case 0:
+			green.Printf("netiCRM 已是 %s，不需要升級。\n", current)
+			return nil
+		case -1:
+			return fmt.Errorf("不支援由 %s 降級到 %s，請使用 %s restore 還原升級前的備份", current, target, programName())
+		}
+	}
+
+	if err := checkNeticrmRelease(target); err != nil {
+		return err
+	}
+
+	if current == "" {
+		current = "未知版本"
+	}
+	fmt.Printf("netiCRM 將由 %s 升級到 %s\n", current, target)
+	if !*yes {
+		confirm := false
+		prompt := &survey.Confirm{
+			Message: "升級前會先完整備份網站，確定要升級嗎？",
+			Default: false,
+		}
+		if err := survey.AskOne(prompt, &confirm); err != nil {
+			return err
+		}
+		if !confirm {
+			fmt.Println("升級取消。")
+			return nil
+		}
+	}
+
+	// 先完整備份，備份失敗就不升級
+	enc, err := newBackupEncryptor(env)
+	if err != nil {
+		return err
+	}
+	set, err := newBackupSet(backupDir, reasonUpgrade)
+	if err != nil {
+		return err
+	}
+	name := "neticrm-site-" + set.CreatedAt.Format(backupTimestamp)
+	if err := backupSite(env, set, name, enc); err != nil {
+		set.remove()
+		return fmt.Errorf("升級前備份失敗，未進行升級: %w", err)
+	}
+	archive := set.path(name + ".tar.gz")
+	if enc != nil {
+		archive += encryptedSuffix
+	}
+
+	cyan.Printf("下載並替換 netiCRM %s ...\n", target)
+	if err := replaceNeticrmModule(target); err != nil {
+		return fmt.Errorf("替換 netiCRM 模組失敗，網站未變更: %w", err)
+	}
+
+	cyan.Println("更新資料庫結構 ...")
+	if err := dockerExec(phpContainer, "drush", "--yes", "updb"); err != nil {
+		red.Println("✗ drush updb 失敗，資料庫可能只完成部分更新。")
+		fmt.Printf("舊版模組保留在 %s.old，可執行以下指令還原升級前的網站：\n", civicrmDir)
+		fmt.Printf("  %s restore %s\n", programName(), archive)
+		return fmt.Errorf("drush updb 失敗: %w", err)
+	}
+	if err := dockerExec(phpContainer, "drush", "--yes", "cr"); err != nil {
+		return fmt.Errorf("drush cr 失敗: %w", err)
+	}
+
+	if err := updateEnvFile(map[string]string{"NETICRM_VERSION": target}); err != nil {
+		return fmt.Errorf("更新 %s 失敗: %w", targetFile, err)
+	}
+	if err := exec.Command("docker", "exec", phpContainer, "rm", "-rf", "/var/www/html/modules/civicrm.old").Run(); err != nil {
+		yellow.Printf("⚠️  無法刪除舊版模組 %s.old: %v\n", civicrmDir, err)
+	}
+
+	green.Printf("✅ netiCRM 已升級到 %s\n", target)
+	fmt.Printf("升級前的備份：%s\n", set.dir)
+	return nil
+}
+
+// upgradeScript 在 neticrm-php 容器內下載新版並替換 modules/civicrm，舊版改名為 civicrm.old
+const upgradeScript = `set -e
+cd /var/www/html/modules
+curl -fsSL -o /tmp/neticrm-upgrade.tar.gz "$NETICRM_URL"
+rm -rf civicrm.new civicrm.old
+mkdir civicrm.new
+tar -xzf /tmp/neticrm-upgrade.tar.gz -C civicrm.new --strip-components=1
+rm -f /tmp/neticrm-upgrade.tar.gz
+mv civicrm civicrm.old
+mv civicrm.new civicrm
+`
+
+func replaceNeticrmModule(v string) error {
+	cmd := exec.Command("docker", "exec", "-e", "NETICRM_URL="+neticrmDownloadURL(v), phpContainer, "bash", "-c", upgradeScript)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
@@ -0,0 +1,384 @@
+// Package envfile 依 docker compose 的規則讀寫 .env 檔案。
+//
+// 寫入時依值的內容選擇寫法，確保 compose 讀到的值與寫入的值完全相同：
+//
+//   - 只含英數字與 _ - . / : @ + , 時不加引號
+//   - 不含單引號、反斜線與換行時使用單引號，compose 不會展開其中的 $
+//   - 其他情況使用雙引號，跳脫 \ " 與換行，並將 $ 寫成 $$
+//
+// 讀取時處理引號、跳脫字元、行尾註解與 $VAR、${VAR:-預設值} 等變數，
+// 變數只會以同一個檔案中先前定義的值展開。
+//
+// 需要修改既有檔案時使用 File，它保留註解、空行與變數的順序。
+package envfile
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Quote 回傳 value 在 .env 中的寫法
+func Quote(value string) string {
+	if isBare(value) {
+		return value
+	}
+	if !strings.ContainsAny(value, "'\\\n\r") {
+		return "'" + value + "'"
+	}
+
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range value {
+		switch r {
+		case '\\':
+			b.WriteString(`\\`)
+		case '"':
+			b.WriteString(`\"`)
+		case '$':
+			b.WriteString("$$")
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// Line 回傳 KEY=value 形式的一行，不含換行字元
+func Line(key, value string) string {
+	return key + "=" + Quote(value)
+}
+
+func isBare(value string) bool {
+	for _, r := range value {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case strings.ContainsRune("_-./:@+,", r):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// ReadFile 讀取並解析 .env 檔案，回傳所有變數
+func ReadFile(path string) (map[string]string, error) {
+	f, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+	return f.Map(), nil
+}
+
+// Parse 解析 .env 內容，回傳所有變數
+func Parse(src string) (map[string]string, error) {
+	f, err := Decode(src)
+	if err != nil {
+		return nil, err
+	}
+	return f.Map(), nil
+}
+
+// statement 是 .env 中的一行，或一個跨越多行的引號值
+type statement struct {
+	// raw 為原始文字，不含最後的換行字元
+	raw string
+	// key 為空代表空行、註解或沒有 = 的行
+	key    string
+	value  string
+	export bool
+	// comment 為值之後的註解，包含前面的空白
+	comment string
+}
+
+type parser struct {
+	src  string
+	pos  int
+	line int
+	vars map[string]string
+}
+
+func newParser(src string) *parser {
+	return &parser{src: src, line: 1, vars: make(map[string]string)}
+}
+
+func (p *parser) done() bool {
+	return p.pos >= len(p.src)
+}
+
+// next 讀取下一個敘述並移到下一行的開頭
+func (p *parser) next() (statement, error) {
+	start := p.pos
+	lineEnd := p.lineEnd(start)
+	text := strings.TrimLeft(p.src[start:lineEnd], " \t")
+
+	if text == "" || text == "\r" || text[0] == '#' {
+		return p.finish(start, lineEnd), nil
+	}
+
+	export := strings.HasPrefix(text, "export ")
+	text = strings.TrimPrefix(text, "export ")
+	eq := strings.IndexByte(text, '=')
+	if eq < 0 {
+		// compose 將只有名稱的行視為沿用系統環境變數，這裡不產生值
+		return p.finish(start, lineEnd), nil
+	}
+	key := strings.TrimRight(text[:eq], " \t")
+	if !validKey(key) {
+		return statement{}, fmt.Errorf("第 %d 行：無效的變數名稱 %q", p.line, key)
+	}
+
+	// 值從 = 之後開始，略過前面的空白
+	valueStart := lineEnd - len(text) + eq + 1
+	for valueStart < lineEnd && (p.src[valueStart] == ' ' || p.src[valueStart] == '\t') {
+		valueStart++
+	}
+
+	var value, comment string
+	var err error
+	end := lineEnd
+	if valueStart < lineEnd && (p.src[valueStart] == '"' || p.src[valueStart] == '\'') {
+		value, end, err = p.quotedValue(valueStart)
+		if err == nil {
+			comment = strings.TrimRight(p.src[end+1:p.lineEnd(end)], " \t\r")
+		}
+	} else {
+		raw := p.src[valueStart:lineEnd]
+		if i := strings.Index(raw, " #"); i >= 0 {
+			raw, comment = raw[:i], strings.TrimRight(raw[i:], "\r")
+		}
+		value, err = p.expand(strings.TrimRight(raw, " \t\r"))
+	}
+	if err != nil {
+		return statement{}, err
+	}
+
+	stmt := p.finish(start, end)
+	stmt.key = key
+	stmt.value = value
+	stmt.export = export
+	stmt.comment = comment
+	return stmt, nil
+}
+
+// finish 讀到 end 所在行的行尾，回傳由 start 開始的原始文字
+func (p *parser) finish(start, end int) statement {
+	end = p.lineEnd(end)
+	raw := p.src[start:end]
+	p.line += strings.Count(raw, "\n") + 1
+	p.pos = end + 1
+	return statement{raw: strings.TrimSuffix(raw, "\r")}
+}
+
+func (p *parser) lineEnd(from int) int {
+	if i := strings.IndexByte(p.src[from:], '\n'); i >= 0 {
+		return from + i
+	}
+	return len(p.src)
+}
+
+// quotedValue 解析由 start 開始的引號值，回傳值與右引號之後的位置
+func (p *parser) quotedValue(start int) (string, int, error) {
+	quote := p.src[start]
+	line := p.line
+	var chars []byte
+	escaped := false
+	for i := start + 1; i < len(p.src); i++ {
+		c := p.src[i]
+		if c == '\n' {
+			line++
+		}
+		if c != quote {
+			if !escaped && c == '\\' {
+				escaped = true
+				continue
+			}
+			if escaped {
+				escaped = false
+				chars = append(chars, '\\')
+			}
+			chars = append(chars, c)
+			continue
+		}
+		if escaped {
+			escaped = false
+			chars = append(chars, c)
+			continue
+		}
+
+		// 右引號之後只允許空白與註解
+		end := p.lineEnd(i)
+		if rest := strings.TrimSpace(p.src[i+1 : end]); rest != "" && rest[0] != '#' {
+			return "", 0, fmt.Errorf("第 %d 行：引號之後有多餘的內容 %q", line, rest)
+		}
+
+		value := string(chars)
+		if quote == '\'' {
+			return value, i, nil
+		}
+		value, err := p.expand(unescape(value))
+		return value, i, err
+	}
+	return "", 0, fmt.Errorf("第 %d 行：引號未結束", p.line)
+}
+
+// unescape 處理雙引號中的跳脫字元，\$ 轉為 $$ 以免被當成變數
+func unescape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 >= len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		switch c := s[i+1]; c {
+		case '$':
+			b.WriteString("$$")
+		case '"', '\\':
+			b.WriteByte(c)
+		case 'a', 'b', 'f', 'n', 'r', 't', 'v':
+			v, _, _, _ := strconv.UnquoteChar(`\`+string(c), '"')
+			b.WriteRune(v)
+		case '0':
+			// \0 加上最多三位數字為八進位字元
+			j := i + 2
+			for j < len(s) && j < i+5 && s[j] >= '0' && s[j] <= '7' {
+				j++
+			}
+			n, err := strconv.ParseUint(s[i+2:j], 8, 8)
+			if j == i+2 || err != nil {
+				n = 0
+			}
+			b.WriteByte(byte(n))
+			i = j - 2
+		default:
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		}
+		i++
+	}
+	return b.String()
+}
+
+// expand 展開 $$、$VAR 與 ${VAR}，支援 :- - :+ + :? ? 運算子
+func (p *parser) expand(s string) (string, error) {
+	if !strings.Contains(s, "$") {
+		return s, nil
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' || i+1 >= len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		switch next := s[i+1]; {
+		case next == '$':
+			b.WriteByte('$')
+			i++
+		case next == '{':
+			end := matchingBrace(s, i+1)
+			if end < 0 {
+				return "", fmt.Errorf("第 %d 行：${ 未結束", p.line)
+			}
+			v, err := p.substitute(s[i+2 : end])
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(v)
+			i = end
+		case isNameStart(next):
+			j := i + 1
+			for j < len(s) && isNameChar(s[j]) {
+				j++
+			}
+			b.WriteString(p.vars[s[i+1:j]])
+			i = j - 1
+		default:
+			b.WriteByte('$')
+		}
+	}
+	return b.String(), nil
+}
+
+func (p *parser) substitute(expr string) (string, error) {
+	n := 0
+	for n < len(expr) && isNameChar(expr[n]) {
+		n++
+	}
+	name, op := expr[:n], expr[n:]
+	if name == "" || !isNameStart(name[0]) {
+		return "", fmt.Errorf("第 %d 行：無效的變數 ${%s}", p.line, expr)
+	}
+	value, set := p.vars[name]
+
+	for _, o := range []string{":-", ":+", ":?", "-", "+", "?"} {
+		if !strings.HasPrefix(op, o) {
+			continue
+		}
+		arg := op[len(o):]
+		empty := !set || (o[0] == ':' && value == "")
+		switch o[len(o)-1] {
+		case '-':
+			if empty {
+				return p.expand(arg)
+			}
+			return value, nil
+		case '+':
+			if empty {
+				return "", nil
+			}
+			return p.expand(arg)
+		case '?':
+			if empty {
+				return "", fmt.Errorf("第 %d 行：%s %s", p.line, name, arg)
+			}
+			return value, nil
+		}
+	}
+	if op != "" {
+		return "", fmt.Errorf("第 %d 行：無效的變數 ${%s}", p.line, expr)
+	}
+	return value, nil
+}
+
+// matchingBrace 回傳與 s[open] 的 { 對應的 } 位置
+func matchingBrace(s string, open int) int {
+	depth := 0
+	for i := open; i < len(s); i++ {
+		switch s[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isNameChar(c byte) bool {
+	return isNameStart(c) || c >= '0' && c <= '9'
+}
+
+func validKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if !isNameChar(key[i]) && !strings.ContainsRune(".-[]", rune(key[i])) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,176 @@
+package envfile
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// 與 cmd/install 的 randomPass 使用相同的字元集
+const (
+	lowercase = "abcdefghijklmnopqrstuvwxyz"
+	uppercase = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	digits    = "0123456789"
+	symbols   = "!@#$%^&*"
+)
+
+func roundTrip(t *testing.T, value string) {
+	t.Helper()
+	src := Line("KEY", value) + "\n"
+	env, err := Parse(src)
+	if err != nil {
+		t.Fatalf("Parse(%q): %v", src, err)
+	}
+	if got := env["KEY"]; got != value {
+		t.Errorf("round trip of %q: wrote %q, read back %q", value, src, got)
+	}
+}
+
+func TestRoundTripCharacterClasses(t *testing.T) {
+	for _, class := range []string{lowercase, uppercase, digits, symbols} {
+		roundTrip(t, class)
+		for _, c := range class {
+			roundTrip(t, string(c))
+			roundTrip(t, "a"+string(c)+"b")
+			roundTrip(t, string(c)+string(c))
+		}
+	}
+}
+
+func TestRoundTripRandomPasswords(t *testing.T) {
+	all := lowercase + uppercase + digits + symbols
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 5000; i++ {
+		b := make([]byte, 4+rnd.Intn(20))
+		for j := range b {
+			b[j] = all[rnd.Intn(len(all))]
+		}
+		roundTrip(t, string(b))
+	}
+}
+
+func TestRoundTripSpecialValues(t *testing.T) {
+	values := []string{
+		"",
+		" ",
+		"  leading and trailing  ",
+		"a #not a comment",
+		"#hash",
+		"$",
+		"$$",
+		"${HOME}",
+		"$HOME",
+		"${A:-b}",
+		`back\slash`,
+		`\`,
+		`\n`,
+		`\$`,
+		`'`,
+		`it's`,
+		`"`,
+		`say "hi"`,
+		`'"$\`,
+		"line1\nline2",
+		"cr\r\nlf",
+		"tab\there",
+		"age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p",
+		"s3://bucket/prefix",
+		"中文密碼",
+	}
+	for _, v := range values {
+		roundTrip(t, v)
+	}
+
+	// 所有可列印的 ASCII 字元
+	var b strings.Builder
+	for c := byte(' '); c <= '~'; c++ {
+		b.WriteByte(c)
+		roundTrip(t, string(c))
+	}
+	roundTrip(t, b.String())
+}
+
+func TestQuote(t *testing.T) {
+	tests := map[string]string{
+		"":                "",
+		"8080":            "8080",
+		"crm.example.org": "crm.example.org",
+		"a b":             "'a b'",
+		"p@ss$word":       "'p@ss$word'",
+		"it's":            `"it's"`,
+		`a\b`:             `"a\\b"`,
+		"$'":              `"$$'"`,
+		"a\nb":            `"a\nb"`,
+	}
+	for in, want := range tests {
+		if got := Quote(in); got != want {
+			t.Errorf("Quote(%q) = %s, want %s", in, got, want)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	src := `# 註解
+
+export A=plain value # comment
+B = "double ${A}"
+C='single ${A}'
+D=$A-$$-${MISSING:-fallback}-${A:+set}
+E="esc \"q\" \\ \$A \n"
+F="multi
+line" # trailing
+G=
+H
+I=${A-x}${EMPTY-y}${EMPTY:-z}
+EMPTY=
+J=${EMPTY-y}${EMPTY:-z}
+K="a#b" #c
+L=a#b
+CRLF=value` + "\r\n" + `LAST=end`
+
+	env, err := Parse(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"A":     "plain value",
+		"B":     "double plain value",
+		"C":     "single ${A}",
+		"D":     "plain value-$-fallback-set",
+		"E":     "esc \"q\" \\ $A \n",
+		"F":     "multi\nline",
+		"G":     "",
+		"I":     "plain valueyz",
+		"EMPTY": "",
+		"J":     "z",
+		"K":     "a#b",
+		"L":     "a#b",
+		"CRLF":  "value",
+		"LAST":  "end",
+	}
+	for k, v := range want {
+		if got, ok := env[k]; !ok || got != v {
+			t.Errorf("%s = %q, want %q", k, got, v)
+		}
+	}
+	if _, ok := env["H"]; ok {
+		t.Errorf("H without = should not produce a value")
+	}
+	if len(env) != len(want) {
+		t.Errorf("got %d keys, want %d: %v", len(env), len(want), env)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, src := range []string{
+		`A="unterminated`,
+		`A='x' junk`,
+		`BAD KEY=1`,
+		`A=${`,
+		`A=${B:?required}`,
+	} {
+		if _, err := Parse(src); err == nil {
+			t.Errorf("Parse(%q) should fail", src)
+		}
+	}
+}
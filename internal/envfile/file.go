@@ -0,0 +1,155 @@
+package envfile
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// File 是解析後的 .env，保留註解、空行與原本的順序，
+// 修改後寫回時只有變更的那一行會重新產生
+type File struct {
+	nodes []statement
+}
+
+// New 回傳空白的 File
+func New() *File {
+	return &File{}
+}
+
+// Load 讀取並解析 .env 檔案
+func Load(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := Decode(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return f, nil
+}
+
+// Decode 解析 .env 內容
+func Decode(src string) (*File, error) {
+	f := New()
+	p := newParser(src)
+	for !p.done() {
+		stmt, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		if stmt.key != "" {
+			p.vars[stmt.key] = stmt.value
+		}
+		f.nodes = append(f.nodes, stmt)
+	}
+	return f, nil
+}
+
+// index 回傳 key 最後一次出現的位置，compose 以最後一次的定義為準
+func (f *File) index(key string) int {
+	for i := len(f.nodes) - 1; i >= 0; i-- {
+		if f.nodes[i].key == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// Get 回傳 key 的值，f 為 nil 時視為空白檔案
+func (f *File) Get(key string) (string, bool) {
+	if f == nil {
+		return "", false
+	}
+	if i := f.index(key); i >= 0 {
+		return f.nodes[i].value, true
+	}
+	return "", false
+}
+
+// Set 設定 key 的值。已存在的變數在原位置更新並保留行尾註解，新的變數加在最後
+func (f *File) Set(key, value string) {
+	i := f.index(key)
+	if i < 0 {
+		f.nodes = append(f.nodes, statement{raw: Line(key, value), key: key, value: value})
+		return
+	}
+
+	n := &f.nodes[i]
+	if n.value == value {
+		return
+	}
+	n.value = value
+	n.raw = Line(key, value) + n.comment
+	if n.export {
+		n.raw = "export " + n.raw
+	}
+}
+
+// SetAll 設定多個變數，新的變數依名稱排序加在最後
+func (f *File) SetAll(values map[string]string) {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		f.Set(key, values[key])
+	}
+}
+
+// Unset 移除 key 的所有定義，回傳 key 是否存在
+func (f *File) Unset(key string) bool {
+	nodes := f.nodes[:0]
+	found := false
+	for _, n := range f.nodes {
+		if n.key == key {
+			found = true
+			continue
+		}
+		nodes = append(nodes, n)
+	}
+	f.nodes = nodes
+	return found
+}
+
+// Keys 依出現順序回傳所有變數名稱
+func (f *File) Keys() []string {
+	var keys []string
+	seen := make(map[string]bool)
+	for _, n := range f.nodes {
+		if n.key != "" && !seen[n.key] {
+			seen[n.key] = true
+			keys = append(keys, n.key)
+		}
+	}
+	return keys
+}
+
+// Map 回傳所有變數
+func (f *File) Map() map[string]string {
+	env := make(map[string]string)
+	for _, n := range f.nodes {
+		if n.key != "" {
+			env[n.key] = n.value
+		}
+	}
+	return env
+}
+
+// String 回傳 .env 內容，每一行以換行字元結尾
+func (f *File) String() string {
+	var b strings.Builder
+	for _, n := range f.nodes {
+		b.WriteString(n.raw)
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// WriteFile 寫入 path，檔案已存在時保留原本的權限
+func (f *File) WriteFile(path string, perm os.FileMode) error {
+	return os.WriteFile(path, []byte(f.String()), perm)
+}
@@ -0,0 +1,113 @@
+package envfile
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const sample = `# 資料庫設定
+MYSQL_PASSWORD=old # 安裝時產生
+
+export SMTP_HOST=mail.example.org
+# 管理員自行加入的變數
+CUSTOM='keep me'
+MULTI="a
+b"
+`
+
+func TestDecodePreservesLayout(t *testing.T) {
+	f, err := Decode(sample)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := f.String(); got != sample {
+		t.Errorf("unchanged file should round trip:\n%s\nwant:\n%s", got, sample)
+	}
+	want := []string{"MYSQL_PASSWORD", "SMTP_HOST", "CUSTOM", "MULTI"}
+	if got := f.Keys(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Keys() = %v, want %v", got, want)
+	}
+}
+
+func TestSetUnset(t *testing.T) {
+	f, err := Decode(sample)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Set("MYSQL_PASSWORD", "n3w$ecret")
+	f.Set("SMTP_HOST", "smtp.example.org")
+	f.Set("CUSTOM", "keep me")
+	if !f.Unset("MULTI") {
+		t.Error("Unset(MULTI) should report the key existed")
+	}
+	if f.Unset("MISSING") {
+		t.Error("Unset(MISSING) should report the key did not exist")
+	}
+	f.SetAll(map[string]string{"ZZZ": "1", "AAA": "2", "CUSTOM": "keep me"})
+
+	want := `# 資料庫設定
+MYSQL_PASSWORD='n3w$ecret' # 安裝時產生
+
+export SMTP_HOST=smtp.example.org
+# 管理員自行加入的變數
+CUSTOM='keep me'
+AAA=2
+ZZZ=1
+`
+	if got := f.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	// 寫出的內容再讀回來應得到相同的值
+	env, err := Parse(f.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(env, f.Map()) {
+		t.Errorf("Parse(String()) = %v, want %v", env, f.Map())
+	}
+	if v, ok := f.Get("MYSQL_PASSWORD"); !ok || v != "n3w$ecret" {
+		t.Errorf("Get(MYSQL_PASSWORD) = %q, %v", v, ok)
+	}
+}
+
+func TestDuplicateKeys(t *testing.T) {
+	f, err := Decode("A=1\nA=2\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := f.Get("A"); v != "2" {
+		t.Errorf("Get(A) = %q, want the last definition", v)
+	}
+	f.Set("A", "3")
+	if got, want := f.String(), "A=1\nA=3\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestLoadWriteFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".env")
+	if err := os.WriteFile(path, []byte("A=1\n\n# c\nB=2"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Set("B", "x y")
+	if err := f.WriteFile(path, 0644); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(data), "A=1\n\n# c\nB='x y'\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("existing file mode should be kept, got %v", info.Mode().Perm())
+	}
+}